@@ -21,7 +21,6 @@ import (
 	"flag"
 	"log"
 
-	"admiralty.io/multicluster-service-account/pkg/config"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/sample-controller/pkg/signals"
 
@@ -44,17 +43,14 @@ func main() {
 	}
 	srcCtx, dstCtx := flag.Arg(0), flag.Arg(1)
 
-	cfg, ns, err := config.NamedConfigAndNamespace(srcCtx)
-	if err != nil {
-		log.Fatal(err)
-	}
-	cl1 := cluster.New(srcCtx, cfg, cluster.Options{CacheOptions: cluster.CacheOptions{Namespace: ns}})
-
-	cfg, ns, err = config.NamedConfigAndNamespace(dstCtx)
-	if err != nil {
+	// The kubeconfig file is only loaded once: the controller keeps the Clusters it's created with,
+	// whereas the source would replace them if their contexts' namespaces changed (see helloworld to watch a ClusterSet).
+	set := cluster.NewClusterSet()
+	src := &cluster.KubeconfigSource{Contexts: []string{srcCtx, dstCtx}, Set: set, UseContextNamespaces: true}
+	if err := src.Load(); err != nil {
 		log.Fatal(err)
 	}
-	cl2 := cluster.New(dstCtx, cfg, cluster.Options{CacheOptions: cluster.CacheOptions{Namespace: ns}})
+	cl1, cl2 := set.Get(srcCtx), set.Get(dstCtx)
 
 	co, err := deploymentcopy.NewController(ctx, cl1, cl2)
 	if err != nil {
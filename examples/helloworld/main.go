@@ -22,7 +22,6 @@ import (
 	"log"
 	"strings"
 
-	"admiralty.io/multicluster-service-account/pkg/config"
 	"k8s.io/api/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/sample-controller/pkg/signals"
@@ -41,21 +40,27 @@ func main() {
 		cancel()
 	}()
 
-	var f = flag.String("contexts", "", "a comma-separated list of contexts to watch, e.g., cluster1,cluster2")
+	var f = flag.String("contexts", "", "a comma-separated list of contexts to watch, e.g., cluster1,cluster2 (default: all contexts)")
 	flag.Parse()
-	kubeCtxs := strings.Split(*f, ",")
-
-	co := controller.New(&reconciler{}, controller.Options{})
+	var kubeCtxs []string
+	if *f != "" {
+		kubeCtxs = strings.Split(*f, ",")
+	}
 
-	for _, kubeCtx := range kubeCtxs {
-		cfg, _, err := config.NamedConfigAndNamespace(kubeCtx)
-		if err != nil {
-			log.Fatal(err)
-		}
-		cl := cluster.New(kubeCtx, cfg, cluster.Options{})
-		if err := co.WatchResourceReconcileObject(ctx, cl, &v1.Pod{}, controller.WatchOptions{}); err != nil {
+	set := cluster.NewClusterSet()
+	src := &cluster.KubeconfigSource{Contexts: kubeCtxs, Set: set}
+	if err := src.Load(); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := src.Start(stopCh); err != nil {
 			log.Fatal(err)
 		}
+	}()
+
+	co := controller.New(&reconciler{}, controller.Options{})
+	if err := co.WatchClusterSetReconcileObject(ctx, set, &v1.Pod{}, controller.WatchOptions{}); err != nil {
+		log.Fatal(err)
 	}
 
 	m := manager.New()
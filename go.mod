@@ -3,6 +3,8 @@ module admiralty.io/multicluster-controller
 go 1.13
 
 require (
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	k8s.io/api v0.18.3
 	k8s.io/apimachinery v0.18.3
 	k8s.io/client-go v0.18.3
 	sigs.k8s.io/controller-runtime v0.6.0
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterSpec defines how to connect to a member cluster, and how to target it.
+type ClusterSpec struct {
+	// KubeconfigSecret references the Secret holding a kubeconfig file for the cluster.
+	KubeconfigSecret KubeconfigSecretReference `json:"kubeconfigSecret"`
+	// Labels describe the cluster, e.g., its region, environment, or provider,
+	// so controllers can target it with label selectors.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// KubeconfigSecretReference references a kubeconfig file in a Secret.
+type KubeconfigSecretReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Key is the key of the kubeconfig file in the Secret's data. Defaults to "config".
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Context is the kubeconfig context to use. Defaults to the current context.
+	// +optional
+	Context string `json:"context,omitempty"`
+}
+
+// Connectivity describes whether a cluster's API server can be reached.
+type Connectivity string
+
+// These are the possible values of ClusterStatus' Connectivity.
+const (
+	ConnectivityUnknown     Connectivity = "Unknown"
+	ConnectivityReady       Connectivity = "Ready"
+	ConnectivityDegraded    Connectivity = "Degraded"
+	ConnectivityUnreachable Connectivity = "Unreachable"
+)
+
+// ClusterStatus defines the observed state of a member cluster.
+type ClusterStatus struct {
+	// ObservedGeneration is the generation of the spec that the status was observed with.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Connectivity is the result of the last probe of the cluster's API server.
+	// +optional
+	Connectivity Connectivity `json:"connectivity,omitempty"`
+	// Message explains why the cluster isn't Ready, if it isn't.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastProbeTime is when the cluster's API server was probed for the last change of the status.
+	// Probes that don't change the status don't update it.
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// KubernetesVersion is the Git version of the cluster's API server, e.g., v1.18.3.
+	// +optional
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// APIGroupVersions are the group versions served by the cluster's API server, e.g., apps/v1,
+	// including the group versions of installed CRDs, sorted.
+	// +optional
+	APIGroupVersions []string `json:"apiGroupVersions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Cluster is a member cluster of the fleet. It is cluster-scoped.
+type Cluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterSpec   `json:"spec,omitempty"`
+	Status ClusterStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterList contains a list of Clusters.
+type ClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Cluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Cluster{}, &ClusterList{})
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records the writes made through the clients of clusters, e.g., to trace cross-cluster writes
+// back to the controllers and reconcile Requests that made them.
+package audit // import "admiralty.io/multicluster-controller/pkg/audit"
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"admiralty.io/multicluster-controller/pkg/reconcile"
+)
+
+// Entry describes a write.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Controller is the name of the controller that made the write, if known (see NewContext).
+	Controller string `json:"controller,omitempty"`
+	// Request is the reconcile Request that triggered the write, if known (see NewContext).
+	Request *Request `json:"request,omitempty"`
+	// Cluster is the name of the target cluster.
+	Cluster string `json:"cluster"`
+	// ImpersonatedUser is the user on behalf of whom the write was made, if any.
+	ImpersonatedUser string `json:"impersonatedUser,omitempty"`
+	// Verb is create, update, patch, delete, or deletecollection.
+	Verb        string `json:"verb"`
+	Subresource string `json:"subresource,omitempty"`
+	APIVersion  string `json:"apiVersion"`
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	// ResourceVersion is the resource version of the object after a successful write, if any.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Error is the error returned by the write, if it failed, e.g., because it was denied.
+	Error string `json:"error,omitempty"`
+}
+
+// Request identifies the source object of a reconcile Request.
+type Request struct {
+	Cluster    string `json:"cluster"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// Sink records Entries.
+type Sink interface {
+	Record(e Entry) error
+}
+
+type contextKey struct{}
+
+type source struct {
+	controller string
+	req        reconcile.Request
+}
+
+// NewContext returns a copy of ctx carrying the name of a controller and the reconcile Request it is processing.
+// Reconcilers should use that context for the writes they make while reconciling req,
+// so they can be attributed in the audit log.
+func NewContext(ctx context.Context, controller string, req reconcile.Request) context.Context {
+	return context.WithValue(ctx, contextKey{}, source{controller: controller, req: req})
+}
+
+// FromContext returns the name of the controller and the reconcile Request carried by ctx, if any.
+func FromContext(ctx context.Context) (controller string, req *reconcile.Request) {
+	s, ok := ctx.Value(contextKey{}).(source)
+	if !ok {
+		return "", nil
+	}
+	return s.controller, &s.req
+}
+
+// SetSource sets the Controller and Request of e from ctx (see NewContext).
+func (e *Entry) SetSource(ctx context.Context) {
+	controller, req := FromContext(ctx)
+	e.Controller = controller
+	if req != nil {
+		e.Request = &Request{
+			Cluster:    req.Context,
+			APIVersion: req.GroupVersionKind.GroupVersion().String(),
+			Kind:       req.GroupVersionKind.Kind,
+			Namespace:  req.Namespace,
+			Name:       req.Name,
+		}
+		if req.GroupVersionKind.Empty() {
+			e.Request.APIVersion = ""
+		}
+	}
+}
+
+// FileSink records Entries as JSON lines in a file.
+type FileSink struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+var _ Sink = &FileSink{}
+
+// NewFileSink opens (or creates) the file at path to append Entries to it.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record implements Sink.
+func (s *FileSink) Record(e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// MemorySink records Entries in memory, e.g., for tests.
+type MemorySink struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+var _ Sink = &MemorySink{}
+
+// Record implements Sink.
+func (s *MemorySink) Record(e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// Entries returns a copy of the recorded Entries.
+func (s *MemorySink) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, len(s.entries))
+	copy(entries, s.entries)
+	return entries
+}
+
+// Reset forgets the recorded Entries.
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+}
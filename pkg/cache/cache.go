@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache implements controller-runtime's Cache interface with shared informers.
+// Unlike controller-runtime's implementation, it can also cache objects as metav1.PartialObjectMetadata,
+// i.e., only their type and object metadata, to reduce memory usage.
+package cache // import "admiralty.io/multicluster-controller/pkg/cache"
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Cache knows how to load Kubernetes objects, fetch informers to receive events for Kubernetes objects,
+// and add indices to fields on the objects stored in the cache.
+// Objects can be typed (registered in the scheme), unstructured.Unstructured,
+// or metav1.PartialObjectMetadata (with their GroupVersionKind set).
+type Cache interface {
+	cache.Cache
+}
+
+// Options is used as an argument of New.
+type Options struct {
+	// Scheme is used to map typed objects to GroupVersionKinds. Defaults to the client-go scheme.
+	Scheme *runtime.Scheme
+	// Mapper is used to map GroupVersionKinds to resources. Defaults to a discovery RESTMapper.
+	Mapper meta.RESTMapper
+	// Resync is the period between cache resyncs. Defaults to 10 hours.
+	Resync *time.Duration
+	// Namespace can be used to watch only a single namespace.
+	// If unset (Namespace == ""), all namespaces are watched.
+	Namespace string
+	// Namespaces can be used to watch a set of namespaces, with one informer per namespace (and resource).
+	// Reads are merged across namespaces, and reading outside of them results in an error
+	// for which IsNamespaceNotAllowedErr is true. Namespaces and Namespace are mutually exclusive.
+	Namespaces []string
+	// SelectorsByGVK restricts the objects listed and watched by the cache, per GroupVersionKind, on the server side.
+	// Objects of other GroupVersionKinds are not restricted.
+	SelectorsByGVK map[schema.GroupVersionKind]Selector
+	// TransformsByGVK mutates objects, per GroupVersionKind, before they are stored in the cache,
+	// e.g., to strip fields that aren't needed, to reduce memory usage.
+	TransformsByGVK map[schema.GroupVersionKind]TransformFunc
+	// DefaultTransform, if set, mutates objects whose GroupVersionKinds are not in TransformsByGVK.
+	DefaultTransform TransformFunc
+}
+
+// TransformFunc mutates an object before it is stored in the cache.
+// Objects read from the cache or received by event handlers are transformed objects.
+// Transforms must not change the namespace or name of objects, and must be safe for concurrent use.
+// Beware that updating a transformed object also writes the transformation (e.g., removed annotations);
+// patch objects instead if that is not intended.
+type TransformFunc func(obj runtime.Object)
+
+// StripManagedFields is a TransformFunc that removes managed fields
+// and the kubectl.kubernetes.io/last-applied-configuration annotation, which are rarely used by controllers.
+func StripManagedFields(obj runtime.Object) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	m.SetManagedFields(nil)
+	if a := m.GetAnnotations(); a != nil {
+		if _, ok := a[lastAppliedConfigAnnotation]; ok {
+			delete(a, lastAppliedConfigAnnotation)
+			m.SetAnnotations(a)
+		}
+	}
+}
+
+// from k8s.io/api/core/v1 (no need to import package for just the one constant)
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Selector is a server-side label and/or field selector. Nil selectors select everything.
+// Objects that don't match the selector are neither watched nor cached,
+// so they cannot be read from the cache (getting them results in a NotFound error).
+type Selector struct {
+	Label labels.Selector
+	Field fields.Selector
+}
+
+// applyTo sets the label and field selectors of opts.
+func (s Selector) applyTo(opts *metav1.ListOptions) {
+	if s.Label != nil {
+		opts.LabelSelector = s.Label.String()
+	}
+	if s.Field != nil {
+		opts.FieldSelector = s.Field.String()
+	}
+}
+
+var defaultResync = 10 * time.Hour
+
+// New creates a new Cache.
+func New(config *rest.Config, o Options) (Cache, error) {
+	if o.Scheme == nil {
+		o.Scheme = scheme.Scheme
+	}
+	if o.Mapper == nil {
+		m, err := apiutil.NewDiscoveryRESTMapper(config)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create RESTMapper: %v", err)
+		}
+		o.Mapper = m
+	}
+	if o.Resync == nil {
+		o.Resync = &defaultResync
+	}
+	if len(o.Namespaces) > 0 {
+		if o.Namespace != "" {
+			return nil, fmt.Errorf("cache options Namespace and Namespaces are mutually exclusive")
+		}
+		return newMultiNamespaceCache(config, o)
+	}
+	return newInformerCache(config, o)
+}
+
+// GVKForObject returns the GroupVersionKind of obj.
+// Contrary to apiutil.GVKForObject, it supports metav1.PartialObjectMetadata and metav1.PartialObjectMetadataList,
+// whose GroupVersionKinds must be set in their TypeMeta.
+func GVKForObject(obj runtime.Object, s *runtime.Scheme) (schema.GroupVersionKind, error) {
+	switch obj.(type) {
+	case *metav1.PartialObjectMetadata, *metav1.PartialObjectMetadataList:
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if gvk.Kind == "" || gvk.Version == "" {
+			return schema.GroupVersionKind{}, fmt.Errorf("%T must have its GroupVersionKind set", obj)
+		}
+		return gvk, nil
+	}
+	return apiutil.GVKForObject(obj, s)
+}
+
+type objectKind int
+
+const (
+	structuredKind objectKind = iota
+	unstructuredKind
+	metadataKind
+)
+
+func kindOf(obj runtime.Object) objectKind {
+	switch obj.(type) {
+	case *unstructured.Unstructured, *unstructured.UnstructuredList:
+		return unstructuredKind
+	case *metav1.PartialObjectMetadata, *metav1.PartialObjectMetadataList:
+		return metadataKind
+	}
+	return structuredKind
+}
+
+// informerKey identifies an informer: the same GVK can be cached as typed, unstructured, or metadata objects.
+type informerKey struct {
+	gvk  schema.GroupVersionKind
+	kind objectKind
+}
+
+// itemGVKForList returns the GroupVersionKind of the items of list.
+func itemGVKForList(list runtime.Object, s *runtime.Scheme) (schema.GroupVersionKind, error) {
+	gvk, err := GVKForObject(list, s)
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	if !strings.HasSuffix(gvk.Kind, "List") {
+		return schema.GroupVersionKind{}, fmt.Errorf("non-list type %T (kind %q) passed as output", list, gvk)
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	return gvk, nil
+}
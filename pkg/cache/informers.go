@@ -0,0 +1,391 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+var _ Cache = &informerCache{}
+
+// informerCache lazily creates one shared index informer per GroupVersionKind and object kind
+// (typed, unstructured, or metadata), and serves reads from their indexers.
+type informerCache struct {
+	config     *rest.Config
+	scheme     *runtime.Scheme
+	mapper     meta.RESTMapper
+	resync     time.Duration
+	namespace  string
+	selectors  map[schema.GroupVersionKind]Selector
+	transforms map[schema.GroupVersionKind]TransformFunc
+	transform  TransformFunc
+	codecs     serializer.CodecFactory
+	paramCodec runtime.ParameterCodec
+
+	dynamicClient  dynamic.Interface
+	metadataClient metadata.Interface
+
+	mu        sync.RWMutex
+	informers map[informerKey]*informer
+	started   bool
+	stop      <-chan struct{}
+	startWait chan struct{}
+}
+
+type informer struct {
+	toolscache.SharedIndexInformer
+	reader *reader
+}
+
+func newInformerCache(config *rest.Config, o Options) (*informerCache, error) {
+	dc, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mc, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &informerCache{
+		config:         config,
+		scheme:         o.Scheme,
+		mapper:         o.Mapper,
+		resync:         *o.Resync,
+		namespace:      o.Namespace,
+		selectors:      o.SelectorsByGVK,
+		transforms:     o.TransformsByGVK,
+		transform:      o.DefaultTransform,
+		codecs:         serializer.NewCodecFactory(o.Scheme),
+		paramCodec:     runtime.NewParameterCodec(o.Scheme),
+		dynamicClient:  dc,
+		metadataClient: mc,
+		informers:      make(map[informerKey]*informer),
+		startWait:      make(chan struct{}),
+	}, nil
+}
+
+// Get implements client.Reader.
+func (c *informerCache) Get(ctx context.Context, key client.ObjectKey, out runtime.Object) error {
+	gvk, err := GVKForObject(out, c.scheme)
+	if err != nil {
+		return err
+	}
+
+	i, err := c.getReadyInformer(ctx, informerKey{gvk, kindOf(out)}, out)
+	if err != nil {
+		return err
+	}
+	return i.reader.Get(ctx, key, out)
+}
+
+// List implements client.Reader.
+func (c *informerCache) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	gvk, err := itemGVKForList(list, c.scheme)
+	if err != nil {
+		return err
+	}
+
+	var obj runtime.Object
+	k := kindOf(list)
+	switch k {
+	case unstructuredKind:
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		obj = u
+	case metadataKind:
+		m := &metav1.PartialObjectMetadata{}
+		m.SetGroupVersionKind(gvk)
+		obj = m
+	default:
+		obj, err = c.scheme.New(gvk)
+		if err != nil {
+			return err
+		}
+	}
+
+	i, err := c.getReadyInformer(ctx, informerKey{gvk, k}, obj)
+	if err != nil {
+		return err
+	}
+	return i.reader.List(ctx, list, opts...)
+}
+
+// getReadyInformer gets or creates an informer and, if the cache is started, waits for it to sync.
+func (c *informerCache) getReadyInformer(ctx context.Context, key informerKey, obj runtime.Object) (*informer, error) {
+	i, started, err := c.getInformer(key, obj)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, &cache.ErrCacheNotStarted{}
+	}
+	if !i.HasSynced() {
+		if !toolscache.WaitForCacheSync(ctx.Done(), i.HasSynced) {
+			return nil, apierrors.NewTimeoutError(fmt.Sprintf("failed waiting for %s informer to sync", key.gvk), 0)
+		}
+	}
+	return i, nil
+}
+
+// GetInformer implements cache.Informers.
+func (c *informerCache) GetInformer(ctx context.Context, obj runtime.Object) (cache.Informer, error) {
+	gvk, err := GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	i, _, err := c.getInformer(informerKey{gvk, kindOf(obj)}, obj)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// GetInformerForKind implements cache.Informers. The informer caches typed objects.
+func (c *informerCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	obj, err := c.scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	i, _, err := c.getInformer(informerKey{gvk, structuredKind}, obj)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// IndexField implements client.FieldIndexer.
+func (c *informerCache) IndexField(ctx context.Context, obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	i, err := c.GetInformer(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return i.AddIndexers(toolscache.Indexers{fieldIndexName(field): indexFunc(extractValue)})
+}
+
+// Start runs the informers already created, and those created later, until stop is closed.
+// Start blocks until then.
+func (c *informerCache) Start(stop <-chan struct{}) error {
+	func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.stop = stop
+		for _, i := range c.informers {
+			go i.Run(stop)
+		}
+		c.started = true
+		close(c.startWait)
+	}()
+	<-stop
+	return nil
+}
+
+// WaitForCacheSync waits for the cache to be started and for the informers created so far to sync,
+// or until stop is closed. It returns false if stop was closed first.
+func (c *informerCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	select {
+	case <-c.startWait:
+	case <-stop:
+		return false
+	}
+
+	c.mu.RLock()
+	synced := make([]toolscache.InformerSynced, 0, len(c.informers))
+	for _, i := range c.informers {
+		synced = append(synced, i.HasSynced)
+	}
+	c.mu.RUnlock()
+
+	return toolscache.WaitForCacheSync(stop, synced...)
+}
+
+func (c *informerCache) getInformer(key informerKey, obj runtime.Object) (*informer, bool, error) {
+	c.mu.RLock()
+	i, ok := c.informers[key]
+	started := c.started
+	c.mu.RUnlock()
+	if ok {
+		return i, started, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i, ok := c.informers[key]; ok {
+		return i, c.started, nil
+	}
+
+	lw, err := c.newListWatch(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// metadata informers store PartialObjectMetadata, whatever the type of obj
+	var objType runtime.Object = obj
+	if key.kind == metadataKind {
+		objType = &metav1.PartialObjectMetadata{}
+	}
+
+	si := toolscache.NewSharedIndexInformer(lw, objType, resyncPeriod(c.resync), toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+	})
+	i = &informer{
+		SharedIndexInformer: si,
+		reader:              &reader{indexer: si.GetIndexer(), gvk: key.gvk},
+	}
+	c.informers[key] = i
+
+	if c.started {
+		go i.Run(c.stop)
+	}
+	return i, c.started, nil
+}
+
+func (c *informerCache) newListWatch(key informerKey) (*toolscache.ListWatch, error) {
+	lw, err := c.newUnfilteredListWatch(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sel, hasSelector := c.selectors[key.gvk]
+	transform, ok := c.transforms[key.gvk]
+	if !ok {
+		transform = c.transform
+	}
+	if !hasSelector && transform == nil {
+		return lw, nil
+	}
+
+	listFunc, watchFunc := lw.ListFunc, lw.WatchFunc
+	lw.ListFunc = func(opts metav1.ListOptions) (runtime.Object, error) {
+		sel.applyTo(&opts)
+		list, err := listFunc(opts)
+		if err != nil || transform == nil {
+			return list, err
+		}
+		if err := meta.EachListItem(list, func(obj runtime.Object) error {
+			transform(obj)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+	lw.WatchFunc = func(opts metav1.ListOptions) (watch.Interface, error) {
+		sel.applyTo(&opts)
+		w, err := watchFunc(opts)
+		if err != nil || transform == nil {
+			return w, err
+		}
+		return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+			switch e.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				transform(e.Object)
+			}
+			return e, true
+		}), nil
+	}
+	return lw, nil
+}
+
+func (c *informerCache) newUnfilteredListWatch(key informerKey) (*toolscache.ListWatch, error) {
+	gvk := key.gvk
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	ns := ""
+	if mapping.Scope.Name() != meta.RESTScopeNameRoot {
+		ns = c.namespace
+	}
+
+	ctx := context.TODO()
+
+	switch key.kind {
+	case unstructuredKind:
+		ri := c.dynamicClient.Resource(mapping.Resource).Namespace(ns)
+		return &toolscache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return ri.List(ctx, opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.Watch = true
+				return ri.Watch(ctx, opts)
+			},
+		}, nil
+
+	case metadataKind:
+		ri := c.metadataClient.Resource(mapping.Resource).Namespace(ns)
+		return &toolscache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return ri.List(ctx, opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.Watch = true
+				return ri.Watch(ctx, opts)
+			},
+		}, nil
+	}
+
+	rc, err := apiutil.RESTClientForGVK(gvk, c.config, c.codecs)
+	if err != nil {
+		return nil, err
+	}
+	listObj, err := c.scheme.New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err != nil {
+		return nil, err
+	}
+	return &toolscache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			res := listObj.DeepCopyObject()
+			err := rc.Get().NamespaceIfScoped(ns, ns != "").Resource(mapping.Resource.Resource).
+				VersionedParams(&opts, c.paramCodec).Do(ctx).Into(res)
+			return res, err
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.Watch = true
+			return rc.Get().NamespaceIfScoped(ns, ns != "").Resource(mapping.Resource.Resource).
+				VersionedParams(&opts, c.paramCodec).Watch(ctx)
+		},
+	}, nil
+}
+
+// resyncPeriod returns a jittered resync period, so informers don't all resync at the same time.
+func resyncPeriod(resync time.Duration) time.Duration {
+	factor := rand.Float64()/5.0 + 0.9
+	return time.Duration(float64(resync.Nanoseconds()) * factor)
+}
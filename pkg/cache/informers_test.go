@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newTestInformerCache returns an informerCache that knows about Pods. Its informers aren't run in these tests,
+// so no API server is needed.
+func newTestInformerCache(t *testing.T) *informerCache {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(podGVK, meta.RESTScopeNamespace)
+	ca, err := New(&rest.Config{Host: "http://127.0.0.1:0"}, Options{Scheme: scheme.Scheme, Mapper: mapper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca.(*informerCache)
+}
+
+func TestInformerCacheNotStarted(t *testing.T) {
+	c := newTestInformerCache(t)
+
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "a"}, &corev1.Pod{})
+	if _, ok := err.(*cache.ErrCacheNotStarted); !ok {
+		t.Errorf("Get: got error %v, expected ErrCacheNotStarted", err)
+	}
+
+	err = c.List(context.Background(), &corev1.PodList{})
+	if _, ok := err.(*cache.ErrCacheNotStarted); !ok {
+		t.Errorf("List: got error %v, expected ErrCacheNotStarted", err)
+	}
+
+	m := &metav1.PartialObjectMetadataList{}
+	m.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	err = c.List(context.Background(), m)
+	if _, ok := err.(*cache.ErrCacheNotStarted); !ok {
+		t.Errorf("List metadata: got error %v, expected ErrCacheNotStarted", err)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if c.WaitForCacheSync(stop) {
+		t.Error("WaitForCacheSync returned true for a cache that isn't started")
+	}
+}
+
+func TestInformerCacheInformersByKind(t *testing.T) {
+	c := newTestInformerCache(t)
+	ctx := context.Background()
+
+	typed, err := c.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forKind, err := c.GetInformerForKind(ctx, podGVK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed != forKind {
+		t.Error("GetInformer and GetInformerForKind returned different informers for typed Pods")
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(podGVK)
+	unstructuredInformer, err := c.GetInformer(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &metav1.PartialObjectMetadata{}
+	m.SetGroupVersionKind(podGVK)
+	metadataInformer, err := c.GetInformer(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unstructuredInformer == typed || metadataInformer == typed || metadataInformer == unstructuredInformer {
+		t.Error("typed, unstructured, and metadata Pods share informers")
+	}
+	if n := len(c.informers); n != 3 {
+		t.Errorf("got %d informers, expected 3", n)
+	}
+
+	if _, err := c.GetInformer(ctx, &metav1.PartialObjectMetadata{}); err == nil {
+		t.Error("got no error for a metadata object without GroupVersionKind")
+	}
+}
+
+func TestItemGVKForList(t *testing.T) {
+	gvk, err := itemGVKForList(&corev1.PodList{}, scheme.Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gvk != podGVK {
+		t.Errorf("got %s, expected %s", gvk, podGVK)
+	}
+
+	if _, err := itemGVKForList(&corev1.Pod{}, scheme.Scheme); err == nil {
+		t.Error("got no error for a non-list type")
+	}
+}
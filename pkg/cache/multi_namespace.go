@@ -0,0 +1,283 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ Cache = &multiNamespaceCache{}
+
+// multiNamespaceCache watches a set of namespaces with one informerCache per namespace,
+// and cluster-scoped resources with another informerCache.
+type multiNamespaceCache struct {
+	namespaces    map[string]*informerCache
+	clusterScoped *informerCache
+	*multiNamespaceReader
+}
+
+func newMultiNamespaceCache(config *rest.Config, o Options) (*multiNamespaceCache, error) {
+	c := &multiNamespaceCache{namespaces: make(map[string]*informerCache, len(o.Namespaces))}
+	readers := make(map[string]client.Reader, len(o.Namespaces))
+	for _, ns := range o.Namespaces {
+		nso := o
+		nso.Namespace = ns
+		ic, err := newInformerCache(config, nso)
+		if err != nil {
+			return nil, err
+		}
+		c.namespaces[ns] = ic
+		readers[ns] = ic
+	}
+	ic, err := newInformerCache(config, o)
+	if err != nil {
+		return nil, err
+	}
+	c.clusterScoped = ic
+	c.multiNamespaceReader = &multiNamespaceReader{namespaces: readers, clusterScoped: ic, scheme: o.Scheme, mapper: o.Mapper}
+	return c, nil
+}
+
+// GetInformer implements cache.Informers.
+func (c *multiNamespaceCache) GetInformer(ctx context.Context, obj runtime.Object) (cache.Informer, error) {
+	gvk, err := GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	return c.getInformer(gvk, func(ic *informerCache) (cache.Informer, error) {
+		return ic.GetInformer(ctx, obj)
+	})
+}
+
+// GetInformerForKind implements cache.Informers.
+func (c *multiNamespaceCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	return c.getInformer(gvk, func(ic *informerCache) (cache.Informer, error) {
+		return ic.GetInformerForKind(ctx, gvk)
+	})
+}
+
+func (c *multiNamespaceCache) getInformer(gvk schema.GroupVersionKind, get func(ic *informerCache) (cache.Informer, error)) (cache.Informer, error) {
+	namespaced, err := c.isNamespaced(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if !namespaced {
+		return get(c.clusterScoped)
+	}
+	mi := multiNamespaceInformer{}
+	for _, ic := range c.namespaces {
+		i, err := get(ic)
+		if err != nil {
+			return nil, err
+		}
+		mi = append(mi, i)
+	}
+	return mi, nil
+}
+
+// IndexField implements client.FieldIndexer.
+func (c *multiNamespaceCache) IndexField(ctx context.Context, obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	i, err := c.GetInformer(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return i.AddIndexers(toolscache.Indexers{fieldIndexName(field): indexFunc(extractValue)})
+}
+
+// Start implements cache.Informers.
+func (c *multiNamespaceCache) Start(stop <-chan struct{}) error {
+	for _, ic := range c.namespaces {
+		go ic.Start(stop)
+	}
+	return c.clusterScoped.Start(stop)
+}
+
+// WaitForCacheSync implements cache.Informers.
+func (c *multiNamespaceCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	for _, ic := range c.namespaces {
+		if !ic.WaitForCacheSync(stop) {
+			return false
+		}
+	}
+	return c.clusterScoped.WaitForCacheSync(stop)
+}
+
+// multiNamespaceInformer aggregates the informers of a resource in several namespaces.
+type multiNamespaceInformer []cache.Informer
+
+func (mi multiNamespaceInformer) AddEventHandler(h toolscache.ResourceEventHandler) {
+	for _, i := range mi {
+		i.AddEventHandler(h)
+	}
+}
+
+func (mi multiNamespaceInformer) AddEventHandlerWithResyncPeriod(h toolscache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, i := range mi {
+		i.AddEventHandlerWithResyncPeriod(h, resyncPeriod)
+	}
+}
+
+func (mi multiNamespaceInformer) AddIndexers(indexers toolscache.Indexers) error {
+	for _, i := range mi {
+		if err := i.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mi multiNamespaceInformer) HasSynced() bool {
+	for _, i := range mi {
+		if !i.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// RestrictNamespaces returns a client.Reader that only reads namespaced objects in the given namespaces.
+// Reading a namespaced object in another namespace results in an error for which IsNamespaceNotAllowedErr is true.
+// Listing across all namespaces lists in each allowed namespace and merges the results.
+// Cluster-scoped objects are read normally.
+func RestrictNamespaces(r client.Reader, namespaces []string, s *runtime.Scheme, m meta.RESTMapper) client.Reader {
+	readers := make(map[string]client.Reader, len(namespaces))
+	for _, ns := range namespaces {
+		readers[ns] = r
+	}
+	return &multiNamespaceReader{namespaces: readers, clusterScoped: r, scheme: s, mapper: m}
+}
+
+// multiNamespaceReader dispatches reads of namespaced objects to one reader per namespace,
+// and reads of cluster-scoped objects to another reader.
+type multiNamespaceReader struct {
+	namespaces    map[string]client.Reader
+	clusterScoped client.Reader
+	scheme        *runtime.Scheme
+	mapper        meta.RESTMapper
+}
+
+// Get implements client.Reader.
+func (r *multiNamespaceReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	gvk, err := GVKForObject(obj, r.scheme)
+	if err != nil {
+		return err
+	}
+	namespaced, err := r.isNamespaced(gvk)
+	if err != nil {
+		return err
+	}
+	if !namespaced {
+		return r.clusterScoped.Get(ctx, key, obj)
+	}
+	nr, ok := r.namespaces[key.Namespace]
+	if !ok {
+		return r.namespaceNotAllowedErr(key.Namespace)
+	}
+	return nr.Get(ctx, key, obj)
+}
+
+// List implements client.Reader.
+func (r *multiNamespaceReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	gvk, err := itemGVKForList(list, r.scheme)
+	if err != nil {
+		return err
+	}
+	namespaced, err := r.isNamespaced(gvk)
+	if err != nil {
+		return err
+	}
+	if !namespaced {
+		return r.clusterScoped.List(ctx, list, opts...)
+	}
+
+	o := client.ListOptions{}
+	o.ApplyOptions(opts)
+	if o.Namespace != "" {
+		nr, ok := r.namespaces[o.Namespace]
+		if !ok {
+			return r.namespaceNotAllowedErr(o.Namespace)
+		}
+		return nr.List(ctx, list, opts...)
+	}
+
+	if o.Limit > 0 || o.Continue != "" {
+		return fmt.Errorf("paginated lists across namespaces are not supported")
+	}
+
+	var items []runtime.Object
+	for ns, nr := range r.namespaces {
+		l := list.DeepCopyObject()
+		if err := nr.List(ctx, l, append(opts, client.InNamespace(ns))...); err != nil {
+			return err
+		}
+		nsItems, err := meta.ExtractList(l)
+		if err != nil {
+			return err
+		}
+		items = append(items, nsItems...)
+	}
+	return meta.SetList(list, items)
+}
+
+func (r *multiNamespaceReader) isNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	m, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return false, err
+	}
+	return m.Scope.Name() != meta.RESTScopeNameRoot, nil
+}
+
+func (r *multiNamespaceReader) namespaceNotAllowedErr(namespace string) error {
+	allowed := make([]string, 0, len(r.namespaces))
+	for ns := range r.namespaces {
+		allowed = append(allowed, ns)
+	}
+	sort.Strings(allowed)
+	if namespace == "" {
+		return &namespaceNotAllowedErr{s: fmt.Sprintf("a namespace is required to read namespaced objects: only namespaces %s are allowed",
+			strings.Join(allowed, ", "))}
+	}
+	return &namespaceNotAllowedErr{s: fmt.Sprintf("namespace %s is not allowed: only namespaces %s are allowed",
+		namespace, strings.Join(allowed, ", "))}
+}
+
+type namespaceNotAllowedErr struct {
+	s string
+}
+
+func (e *namespaceNotAllowedErr) Error() string {
+	return e.s
+}
+
+// IsNamespaceNotAllowedErr returns true if err was returned because an object was read
+// outside of the namespaces of a multi-namespace cache or reader.
+func IsNamespaceNotAllowedErr(err error) bool {
+	_, ok := err.(*namespaceNotAllowedErr)
+	return ok
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reader reads objects of a GroupVersionKind from an informer's indexer.
+type reader struct {
+	indexer toolscache.Indexer
+	gvk     schema.GroupVersionKind
+}
+
+// Get implements client.Reader.
+func (r *reader) Get(_ context.Context, key client.ObjectKey, out runtime.Object) error {
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.Namespace + "/" + key.Name
+	}
+
+	obj, exists, err := r.indexer.GetByKey(storeKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(schema.GroupResource{Group: r.gvk.Group, Resource: r.gvk.Kind}, key.Name)
+	}
+
+	ro, ok := obj.(runtime.Object)
+	if !ok {
+		return fmt.Errorf("cache contained %T, which is not an Object", obj)
+	}
+	ro = ro.DeepCopyObject()
+
+	outVal := reflect.ValueOf(out)
+	objVal := reflect.ValueOf(ro)
+	if !objVal.Type().AssignableTo(outVal.Type()) {
+		return fmt.Errorf("cache had type %s, but %s was asked for", objVal.Type(), outVal.Type())
+	}
+	reflect.Indirect(outVal).Set(reflect.Indirect(objVal))
+	out.GetObjectKind().SetGroupVersionKind(r.gvk)
+	return nil
+}
+
+// List implements client.Reader. Field selectors must be exact matches on indexed fields (see IndexField).
+func (r *reader) List(_ context.Context, out runtime.Object, opts ...client.ListOption) error {
+	o := client.ListOptions{}
+	o.ApplyOptions(opts)
+
+	var objs []interface{}
+	var err error
+	if o.FieldSelector != nil {
+		field, val, ok := requiresExactMatch(o.FieldSelector)
+		if !ok {
+			return fmt.Errorf("non-exact field matches are not supported by the cache")
+		}
+		objs, err = r.indexer.ByIndex(fieldIndexName(field), namespacedIndexKey(o.Namespace, val))
+	} else if o.Namespace != "" {
+		objs, err = r.indexer.ByIndex(toolscache.NamespaceIndex, o.Namespace)
+	} else {
+		objs = r.indexer.List()
+	}
+	if err != nil {
+		return err
+	}
+
+	items := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		ro, ok := obj.(runtime.Object)
+		if !ok {
+			return fmt.Errorf("cache contained %T, which is not an Object", obj)
+		}
+		if o.LabelSelector != nil {
+			m, err := meta.Accessor(ro)
+			if err != nil {
+				return err
+			}
+			if !o.LabelSelector.Matches(labels.Set(m.GetLabels())) {
+				continue
+			}
+		}
+		ro = ro.DeepCopyObject()
+		ro.GetObjectKind().SetGroupVersionKind(r.gvk)
+		items = append(items, ro)
+	}
+	return meta.SetList(out, items)
+}
+
+func requiresExactMatch(s fields.Selector) (field, val string, ok bool) {
+	reqs := s.Requirements()
+	if len(reqs) != 1 {
+		return "", "", false
+	}
+	req := reqs[0]
+	if req.Operator != selection.Equals && req.Operator != selection.DoubleEquals {
+		return "", "", false
+	}
+	return req.Field, req.Value, true
+}
+
+func fieldIndexName(field string) string {
+	return "field:" + field
+}
+
+const allNamespaces = "__all_namespaces"
+
+func namespacedIndexKey(namespace, val string) string {
+	if namespace == "" {
+		namespace = allNamespaces
+	}
+	return namespace + "/" + val
+}
+
+// indexFunc indexes objects by the values extracted from them, both in their namespace and across all namespaces,
+// so field selectors can be used with or without a namespace.
+func indexFunc(extractValue client.IndexerFunc) toolscache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		ro, ok := obj.(runtime.Object)
+		if !ok {
+			return nil, fmt.Errorf("object of type %T is not an Object", obj)
+		}
+		m, err := meta.Accessor(ro)
+		if err != nil {
+			return nil, err
+		}
+		ns := m.GetNamespace()
+
+		var keys []string
+		for _, v := range extractValue(ro) {
+			keys = append(keys, namespacedIndexKey("", v))
+			if ns != "" {
+				keys = append(keys, namespacedIndexKey(ns, v))
+			}
+		}
+		return keys, nil
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var podGVK = corev1.SchemeGroupVersion.WithKind("Pod")
+
+func newPod(namespace, name, node string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+}
+
+// newPodReader returns a reader of an indexer with the namespace index, as informers have,
+// a spec.nodeName field index (see IndexField), and the given objects.
+func newPodReader(t *testing.T, objs ...interface{}) *reader {
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+		fieldIndexName("spec.nodeName"): indexFunc(func(obj runtime.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.NodeName}
+		}),
+	})
+	for _, obj := range objs {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &reader{indexer: indexer, gvk: podGVK}
+}
+
+func TestReaderGet(t *testing.T) {
+	stored := newPod("ns1", "a", "node1", nil)
+	r := newPodReader(t, stored)
+
+	out := &corev1.Pod{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "a"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "a" || out.Spec.NodeName != "node1" {
+		t.Errorf("got %s on %s, expected a on node1", out.Name, out.Spec.NodeName)
+	}
+	if gvk := out.GroupVersionKind(); gvk != podGVK {
+		t.Errorf("got GVK %s, expected %s", gvk, podGVK)
+	}
+
+	out.Spec.NodeName = "modified"
+	if stored.Spec.NodeName != "node1" {
+		t.Error("Get returned the cached object rather than a copy")
+	}
+
+	err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "a"}, &corev1.Pod{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("got error %v, expected NotFound", err)
+	}
+
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "a"}, &corev1.Node{}); err == nil {
+		t.Error("got no error reading a Pod into a Node")
+	}
+}
+
+func TestReaderGetClusterScoped(t *testing.T) {
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+	if err := indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}); err != nil {
+		t.Fatal(err)
+	}
+	r := &reader{indexer: indexer, gvk: corev1.SchemeGroupVersion.WithKind("Node")}
+
+	out := &corev1.Node{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "node1"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "node1" {
+		t.Errorf("got %s, expected node1", out.Name)
+	}
+}
+
+func TestReaderList(t *testing.T) {
+	r := newPodReader(t,
+		newPod("ns1", "a", "node1", map[string]string{"app": "x"}),
+		newPod("ns1", "b", "node2", map[string]string{"app": "y"}),
+		newPod("ns2", "c", "node1", map[string]string{"app": "x"}),
+	)
+
+	cases := []struct {
+		name     string
+		opts     []client.ListOption
+		expected []string
+	}{
+		{"all", nil, []string{"ns1/a", "ns1/b", "ns2/c"}},
+		{"namespace", []client.ListOption{client.InNamespace("ns1")}, []string{"ns1/a", "ns1/b"}},
+		{"labels", []client.ListOption{client.MatchingLabels{"app": "x"}}, []string{"ns1/a", "ns2/c"}},
+		{"field", []client.ListOption{client.MatchingFields{"spec.nodeName": "node1"}}, []string{"ns1/a", "ns2/c"}},
+		{"field in namespace", []client.ListOption{client.InNamespace("ns2"), client.MatchingFields{"spec.nodeName": "node1"}}, []string{"ns2/c"}},
+		{"field and labels", []client.ListOption{client.MatchingFields{"spec.nodeName": "node1"}, client.MatchingLabels{"app": "y"}}, nil},
+		{"empty namespace", []client.ListOption{client.InNamespace("ns3")}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			list := &corev1.PodList{}
+			if err := r.List(context.Background(), list, c.opts...); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, p := range list.Items {
+				if gvk := p.GroupVersionKind(); gvk != podGVK {
+					t.Errorf("got GVK %s for %s/%s, expected %s", gvk, p.Namespace, p.Name, podGVK)
+				}
+				keys = append(keys, p.Namespace+"/"+p.Name)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, c.expected) {
+				t.Errorf("got %v, expected %v", keys, c.expected)
+			}
+		})
+	}
+}
+
+func TestReaderListNonExactFieldSelector(t *testing.T) {
+	r := newPodReader(t, newPod("ns1", "a", "node1", nil))
+
+	sel := fields.OneTermNotEqualSelector("spec.nodeName", "node1")
+	if err := r.List(context.Background(), &corev1.PodList{}, client.MatchingFieldsSelector{Selector: sel}); err == nil {
+		t.Error("got no error for a non-exact field selector")
+	}
+
+	sel = fields.AndSelectors(fields.OneTermEqualSelector("spec.nodeName", "node1"), fields.OneTermEqualSelector("metadata.name", "a"))
+	if err := r.List(context.Background(), &corev1.PodList{}, client.MatchingFieldsSelector{Selector: sel}); err == nil {
+		t.Error("got no error for a field selector with several requirements")
+	}
+}
+
+func TestReaderMetadata(t *testing.T) {
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+	})
+	for _, name := range []string{"a", "b"} {
+		if err := indexer.Add(&metav1.PartialObjectMetadata{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: name, Labels: map[string]string{"name": name}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &reader{indexer: indexer, gvk: podGVK}
+
+	out := &metav1.PartialObjectMetadata{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "a"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "a" || out.GroupVersionKind() != podGVK {
+		t.Errorf("got %s of kind %s, expected a of kind %s", out.Name, out.GroupVersionKind(), podGVK)
+	}
+
+	list := &metav1.PartialObjectMetadataList{}
+	if err := r.List(context.Background(), list, client.InNamespace("ns1"), client.MatchingLabelsSelector{
+		Selector: labels.SelectorFromSet(labels.Set{"name": "b"}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "b" || list.Items[0].GroupVersionKind() != podGVK {
+		t.Errorf("got %v, expected b of kind %s", list.Items, podGVK)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clusterClient forwards calls to the client of the Cluster's current cache and config,
+// so the clients returned by GetDelegatingClient keep working after the Cluster is restarted.
+// Writes are checked against the Cluster's ReadOnly option and WritePolicy, and recorded by its AuditSink.
+// Metadata objects (metav1.PartialObjectMetadata) can only be read.
+type clusterClient struct {
+	c *Cluster
+	// impersonate, if not nil, is the user on behalf of whom calls are made (see GetImpersonatingClient),
+	// in which case cacheReads tells whether to read from the Cluster's cache.
+	impersonate *rest.ImpersonationConfig
+	cacheReads  bool
+}
+
+var _ client.Client = &clusterClient{}
+
+func (cc *clusterClient) current() (client.Client, error) {
+	cc.c.deps.mu.Lock()
+	defer cc.c.deps.mu.Unlock()
+
+	if cc.c.deps.stopped {
+		return nil, &stoppedErr{s: fmt.Sprintf("cluster %s is stopped", cc.c.Name)}
+	}
+	if cc.impersonate != nil {
+		return cc.c.getImpersonatingClient(*cc.impersonate, cc.cacheReads)
+	}
+	return cc.c.getClient()
+}
+
+// newWrite describes a write of obj (see Cluster.newWrite) by the client.
+func (cc *clusterClient) newWrite(verb, subresource string, obj runtime.Object, namespace string) Write {
+	w := cc.c.newWrite(verb, subresource, obj, namespace)
+	if cc.impersonate != nil {
+		w.ImpersonatedUser = cc.impersonate.UserName
+	}
+	return w
+}
+
+func (cc *clusterClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	cl, err := cc.current()
+	if err != nil {
+		return err
+	}
+	return cl.Get(ctx, key, obj)
+}
+
+func (cc *clusterClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	cl, err := cc.current()
+	if err != nil {
+		return err
+	}
+	return cl.List(ctx, list, opts...)
+}
+
+func (cc *clusterClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return cc.write(ctx, cc.newWrite("create", "", obj, ""), func(cl client.Client) error {
+		return cl.Create(ctx, obj, opts...)
+	})
+}
+
+func (cc *clusterClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return cc.write(ctx, cc.newWrite("delete", "", obj, ""), func(cl client.Client) error {
+		return cl.Delete(ctx, obj, opts...)
+	})
+}
+
+func (cc *clusterClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return cc.write(ctx, cc.newWrite("update", "", obj, ""), func(cl client.Client) error {
+		return cl.Update(ctx, obj, opts...)
+	})
+}
+
+func (cc *clusterClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return cc.write(ctx, cc.newWrite("patch", "", obj, ""), func(cl client.Client) error {
+		return cl.Patch(ctx, obj, patch, opts...)
+	})
+}
+
+func (cc *clusterClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	o := client.DeleteAllOfOptions{}
+	o.ApplyOptions(opts)
+	return cc.write(ctx, cc.newWrite("deletecollection", "", obj, o.Namespace), func(cl client.Client) error {
+		return cl.DeleteAllOf(ctx, obj, opts...)
+	})
+}
+
+// write checks w against the Cluster's write guards, calls f with the current client if allowed,
+// and records the result in the Cluster's audit log.
+// Writes of metadata objects are rejected beforehand, and not recorded, as controller-runtime's clients can't make them.
+func (cc *clusterClient) write(ctx context.Context, w Write, f func(cl client.Client) error) error {
+	switch w.Object.(type) {
+	case *metav1.PartialObjectMetadata, *metav1.PartialObjectMetadataList:
+		return fmt.Errorf("cannot %s in cluster %s: metadata objects are read-only, write typed or unstructured objects instead", w, cc.c.Name)
+	}
+
+	err := cc.c.checkWrite(w)
+	if err == nil {
+		var cl client.Client
+		cl, err = cc.current()
+		if err == nil {
+			err = f(cl)
+		}
+	}
+	cc.c.audit(ctx, w, err)
+	return err
+}
+
+func (cc *clusterClient) Status() client.StatusWriter {
+	return &clusterStatusWriter{cc}
+}
+
+type clusterStatusWriter struct {
+	cc *clusterClient
+}
+
+func (w *clusterStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return w.cc.write(ctx, w.cc.newWrite("update", "status", obj, ""), func(cl client.Client) error {
+		return cl.Status().Update(ctx, obj, opts...)
+	})
+}
+
+func (w *clusterStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.cc.write(ctx, w.cc.newWrite("patch", "status", obj, ""), func(cl client.Client) error {
+		return cl.Status().Patch(ctx, obj, patch, opts...)
+	})
+}
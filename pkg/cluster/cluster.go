@@ -20,36 +20,145 @@ package cluster // import "admiralty.io/multicluster-controller/pkg/cluster"
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/time/rate"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	clientgocache "k8s.io/client-go/tools/cache"
-	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"admiralty.io/multicluster-controller/pkg/audit"
+	"admiralty.io/multicluster-controller/pkg/cache"
 )
 
 // Cluster stores a Kubernetes client, cache, and other cluster-scoped dependencies.
 // The dependencies are lazily created in getters and cached for reuse.
+// Getters are safe for concurrent use. Clusters must be created with New (or CloneWithName).
 type Cluster struct {
-	Name   string
+	Name string
+	// Config is the config the Cluster was created with.
+	// Restart can replace it; GetConfig returns the current config.
 	Config *rest.Config
-	scheme *runtime.Scheme
-	mapper meta.RESTMapper
-	cache  cache.Cache
-	client *client.DelegatingClient
+	deps   *dependencies
 	Options
 }
 
+// dependencies are shared by a Cluster and its clones,
+// so they share the same cache and client, whether cloned before or after the dependencies are created.
+// The mapper, cache, and client are replaced when the Cluster is restarted.
+type dependencies struct {
+	mu       sync.Mutex
+	config   *rest.Config
+	mapper   meta.RESTMapper
+	cache    cache.Cache
+	client   *client.DelegatingClient // of the current cache and config
+	facade   *client.DelegatingClient // forwards to the current client, see GetDelegatingClient
+	handlers []eventHandler
+	pending  []eventHandler // handlers whose resources aren't served yet
+
+	impersonating map[string]client.Client // by impersonation config and whether reads are cached
+
+	started   bool            // Start was called
+	stop      <-chan struct{} // Start's stop channel
+	cacheStop chan struct{}   // closed to stop the current cache
+	stopped   bool            // Stop was called, or Restart failed
+	lifecycle uint64          // incremented by Stop and Restart, so Restart can tell if it was superseded
+
+	transport   *rotatingTransport // if the Cluster has a ConfigProvider
+	refreshMu   sync.Mutex
+	refreshing  bool
+	lastRefresh time.Time
+
+	throttle *throttle // if the Cluster has ThrottlingOptions
+
+	uidMu      sync.Mutex   // serializes cluster UID discoveries, without holding mu
+	uid        atomic.Value // types.UID, once discovered, read without uidMu by KnownClusterUID
+	uidErr     error        // of the last failed discovery
+	uidRetryAt time.Time    // after which a failed discovery can be retried
+
+	healthMu       sync.Mutex
+	health         Health
+	healthWatchers []chan HealthEvent
+}
+
+// eventHandler is recorded by AddEventHandler to be added again to the informers of a new cache when restarting.
+type eventHandler struct {
+	objectType runtime.Object
+	handler    clientgocache.ResourceEventHandler
+}
+
 // Options is used as an argument of New.
-// For now it only embeds CacheOptions but we could add non-cache options in the future.
 type Options struct {
+	// Labels describe the Cluster, e.g., its region, environment, or provider,
+	// so it can be targeted with a label selector (see ClusterSet's Select).
+	Labels map[string]string
+	// Scheme is used by the Cluster's cache and client to map Go types to GroupVersionKinds.
+	// If unset, the default client-go scheme is used, which is shared by all Clusters (and other users of client-go)
+	// and cannot hold conflicting type registrations.
+	// Set a custom scheme for a Cluster if it needs to know about custom resources that other Clusters don't.
+	Scheme *runtime.Scheme
+	// ClusterUID can be used to set the Cluster's stable identity
+	// if the UID of the kube-system namespace cannot be read (see GetClusterUID).
+	ClusterUID types.UID
+	// ConfigProvider, if set, is used to refresh the Cluster's config, e.g., when credentials are rotated:
+	// when a request fails with 401 Unauthorized, every ConfigRefreshPeriod if set, or when RefreshConfig is called.
+	// If the config given to New is nil, the initial config is also read from the provider.
+	// Note that bearer token files (rest.Config's BearerTokenFile) are already periodically re-read by client-go.
+	ConfigProvider ConfigProvider
+	// ConfigRefreshPeriod is the period between config refreshes, if the Cluster has a ConfigProvider.
+	// If zero, the config is only refreshed on authentication failures, or when RefreshConfig is called.
+	ConfigRefreshPeriod time.Duration
+	// MapperRefreshInterval is the minimum time between two discoveries of the Cluster's API resources
+	// by its RESTMapper, when a kind or resource isn't found. Defaults to 10 seconds.
+	MapperRefreshInterval time.Duration
+	// PendingWatchRetryPeriod is the period between attempts to start pending watches (see PendingWatches).
+	// Defaults to 30 seconds.
+	PendingWatchRetryPeriod time.Duration
+	// ReadOnly, if true, makes the writes of the Cluster's delegating client fail
+	// with an error for which IsReadOnlyErr is true, for clusters that must only be observed.
+	ReadOnly bool
+	// WritePolicy, if set, allows or denies each write of the Cluster's delegating client, e.g., an AllowList.
+	// Denied writes fail with an error for which IsWriteDeniedErr is true.
+	WritePolicy WritePolicy
+	// AuditSink, if set, records every write of the Cluster's delegating client, allowed or not, and its result.
+	// Reconcilers should use contexts created with audit.NewContext for their writes,
+	// so they can be attributed to a controller and reconcile Request.
+	AuditSink audit.Sink
+	// Throttling limits the rate of requests to the Cluster's API server.
+	Throttling ThrottlingOptions
+	HealthOptions
 	CacheOptions
 }
 
+const defaultMapperRefreshInterval = 10 * time.Second
+
+// HealthOptions configure the health monitor of a Cluster. See GetHealth and WatchHealth.
+type HealthOptions struct {
+	// HealthCheckPeriod is the period between probes of the Cluster's API server, once the Cluster is started.
+	// If zero, the Cluster isn't monitored, though ProbeHealth can still be called.
+	HealthCheckPeriod time.Duration
+	// HealthProbeTimeout is the timeout of a probe. Defaults to 10 seconds.
+	HealthProbeTimeout time.Duration
+	// HealthDegradedLatency is the latency above which a successful probe makes the Cluster Degraded.
+	// Defaults to 2 seconds.
+	HealthDegradedLatency time.Duration
+	// HealthFailureThreshold is the number of consecutive failed probes that make the Cluster Unreachable.
+	// Defaults to 3.
+	HealthFailureThreshold int
+}
+
 // CacheOptions is embedded in Options to configure the new Cluster's cache.
 type CacheOptions struct {
 	// Resync is the period between cache resyncs.
@@ -59,11 +168,28 @@ type CacheOptions struct {
 	// Namespace can be used to watch only a single namespace.
 	// If unset (Namespace == ""), all namespaces are watched.
 	Namespace string
+	// Namespaces can be used to watch a set of namespaces, e.g., if the Cluster's credentials only allow reading those.
+	// The cache runs one informer per namespace (and resource) and merges reads across namespaces.
+	// The delegating client rejects reads outside of those namespaces, whether from the cache or not,
+	// with an error for which cache.IsNamespaceNotAllowedErr is true. Namespaces and Namespace are mutually exclusive.
+	Namespaces []string
+	// SelectorsByGVK can be used to list and watch only the objects matching label and/or field selectors,
+	// per GroupVersionKind, on the server side, e.g., only the pods with a given label.
+	// Contrary to controller.WatchOptions, which filter events on the client side,
+	// this reduces memory usage and the load on the Kubernetes API server.
+	// Objects that don't match are not in the cache, and cannot be read with the delegating client.
+	SelectorsByGVK map[schema.GroupVersionKind]cache.Selector
+	// TransformsByGVK can be used to mutate objects, per GroupVersionKind, before they are stored in the cache,
+	// e.g., to drop data that the Cluster's controllers don't need.
+	TransformsByGVK map[schema.GroupVersionKind]cache.TransformFunc
+	// DefaultTransform, if set, mutates objects of the GroupVersionKinds that are not in TransformsByGVK,
+	// e.g., cache.StripManagedFields.
+	DefaultTransform cache.TransformFunc
 }
 
 // New creates a new Cluster.
 func New(name string, config *rest.Config, o Options) *Cluster {
-	return &Cluster{Name: name, Config: config, Options: o}
+	return &Cluster{Name: name, Config: config, deps: &dependencies{config: config}, Options: o}
 }
 
 // GetClusterName returns the context given when Cluster c was created.
@@ -71,74 +197,255 @@ func (c *Cluster) GetClusterName() string {
 	return c.Name
 }
 
-// GetScheme returns the default client-go scheme.
+// GetLabels returns the Cluster's labels.
+func (c *Cluster) GetLabels() map[string]string {
+	return c.Labels
+}
+
+// GetConfig returns the Cluster's current config.
+// If the Cluster has a ConfigProvider and wasn't given a config, the initial config is read from the provider;
+// if that fails, GetConfig returns nil.
+func (c *Cluster) GetConfig() *rest.Config {
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+	if c.deps.config == nil && c.ConfigProvider != nil {
+		if _, err := c.currentConfig(); err != nil {
+			utilruntime.HandleError(err)
+		}
+	}
+	return c.deps.config
+}
+
+// clusterUIDTimeout bounds discoveries of cluster UIDs, and clusterUIDRetryPeriod is the minimum time between them
+// after a failure, when GetClusterUID returns the last error, because it's called for every controller reference event.
+const (
+	clusterUIDTimeout     = 10 * time.Second
+	clusterUIDRetryPeriod = time.Minute
+)
+
+// GetClusterUID returns a lazily discovered stable identity of the Cluster:
+// Options.ClusterUID if set, or the UID of the kube-system namespace.
+// Unlike the Cluster's name, which is arbitrary, the cluster UID is the same for all the controllers
+// that know the cluster, even under different names. It is recorded in multicluster references.
+// If the discovery fails, e.g., because the cluster is unreachable or RBAC forbids it,
+// the error is returned without retrying for a minute.
+func (c *Cluster) GetClusterUID() (types.UID, error) {
+	if uid, ok := c.KnownClusterUID(); ok {
+		return uid, nil
+	}
+
+	c.deps.uidMu.Lock()
+	defer c.deps.uidMu.Unlock()
+
+	if uid, ok := c.KnownClusterUID(); ok {
+		return uid, nil // discovered meanwhile
+	}
+
+	if c.deps.uidErr != nil && time.Now().Before(c.deps.uidRetryAt) {
+		return "", c.deps.uidErr
+	}
+
+	uid, err := c.discoverClusterUID()
+	if err != nil {
+		c.deps.uidErr = err
+		c.deps.uidRetryAt = time.Now().Add(clusterUIDRetryPeriod)
+		return "", err
+	}
+
+	c.deps.uid.Store(uid)
+	c.deps.uidErr = nil
+	return uid, nil
+}
+
+// KnownClusterUID returns the Cluster's UID and true if it is known, i.e., set in Options or already discovered,
+// without blocking on a discovery. Started Clusters discover their UIDs in the background.
+func (c *Cluster) KnownClusterUID() (types.UID, bool) {
+	if c.ClusterUID != "" {
+		return c.ClusterUID, true
+	}
+	uid, _ := c.deps.uid.Load().(types.UID)
+	return uid, uid != ""
+}
+
+// discoverClusterUIDUntilKnown tries to discover the Cluster's UID every clusterUIDRetryPeriod,
+// until it succeeds or stop is closed.
+func (c *Cluster) discoverClusterUIDUntilKnown(stop <-chan struct{}) {
+	for {
+		_, err := c.GetClusterUID()
+		if err == nil {
+			return
+		}
+		utilruntime.HandleError(fmt.Errorf("cannot discover UID of cluster %s: %v", c.Name, err))
+		select {
+		case <-stop:
+			return
+		case <-time.After(clusterUIDRetryPeriod):
+		}
+	}
+}
+
+// discoverClusterUID gets the UID of the kube-system namespace. The request is made without holding the lock.
+func (c *Cluster) discoverClusterUID() (types.UID, error) {
+	c.deps.mu.Lock()
+	cfg, err := c.clientConfig()
+	c.deps.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	cl, err := corev1client.NewForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterUIDTimeout)
+	defer cancel()
+	ns, err := cl.Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("cannot get kube-system namespace to discover cluster UID: %v", err)
+	}
+	return ns.UID, nil
+}
+
+// GetScheme returns Options.Scheme if set, or the default client-go scheme.
 // It is used by other Cluster getters, and to add custom resources to the scheme.
 func (c *Cluster) GetScheme() *runtime.Scheme {
+	if c.Scheme != nil {
+		return c.Scheme
+	}
 	return scheme.Scheme
 }
 
 // GetMapper returns a lazily created apimachinery RESTMapper.
 // It is used by other Cluster getters. TODO: consider not exporting.
+// The mapper discovers the Cluster's API resources on first use, and again when a kind or resource isn't found,
+// e.g., if a CRD was installed after the Cluster was started, at most every MapperRefreshInterval.
+// Meanwhile, lookups of unknown kinds fail with an error for which apiutil.DelayIfRateLimited returns true.
 func (c *Cluster) GetMapper() (meta.RESTMapper, error) {
-	if c.mapper != nil {
-		return c.mapper, nil
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+	return c.getMapper()
+}
+
+func (c *Cluster) getMapper() (meta.RESTMapper, error) {
+	if c.deps.mapper != nil {
+		return c.deps.mapper, nil
 	}
 
-	mapper, err := apiutil.NewDiscoveryRESTMapper(c.Config)
+	cfg, err := c.clientConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	c.mapper = mapper
+	interval := c.MapperRefreshInterval
+	if interval == 0 {
+		interval = defaultMapperRefreshInterval
+	}
+	mapper, err := apiutil.NewDynamicRESTMapper(cfg, apiutil.WithLazyDiscovery,
+		apiutil.WithLimiter(rate.NewLimiter(rate.Every(interval), 1)))
+	if err != nil {
+		return nil, err
+	}
+
+	c.deps.mapper = mapper
 	return mapper, nil
 }
 
-// GetCache returns a lazily created controller-runtime Cache.
+// GetCache returns a lazily created Cache.
 // It is used by other Cluster getters. TODO: consider not exporting.
+// The cache is replaced when the Cluster is restarted. Use AddEventHandler rather than the cache's informers
+// for event handlers to be added to the new cache.
 func (c *Cluster) GetCache() (cache.Cache, error) {
-	if c.cache != nil {
-		return c.cache, nil
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+	return c.getCache()
+}
+
+func (c *Cluster) getCache() (cache.Cache, error) {
+	if c.deps.cache != nil {
+		return c.deps.cache, nil
+	}
+
+	m, err := c.getMapper()
+	if err != nil {
+		return nil, err
 	}
 
-	m, err := c.GetMapper()
+	cfg, err := c.cacheConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	ca, err := cache.New(c.Config, cache.Options{
-		Scheme:    c.GetScheme(),
-		Mapper:    m,
-		Resync:    c.Resync,
-		Namespace: c.Namespace,
+	ca, err := cache.New(cfg, cache.Options{
+		Scheme:           c.GetScheme(),
+		Mapper:           m,
+		Resync:           c.Resync,
+		Namespace:        c.Namespace,
+		Namespaces:       c.Namespaces,
+		SelectorsByGVK:   c.SelectorsByGVK,
+		TransformsByGVK:  c.TransformsByGVK,
+		DefaultTransform: c.DefaultTransform,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	c.cache = ca
+	c.deps.cache = ca
 	return ca, nil
 }
 
 // GetDelegatingClient returns a lazily created controller-runtime DelegatingClient.
 // It is used by other Cluster getters, and by reconcilers.
+// Typed objects and metav1.PartialObjectMetadata (with their GroupVersionKinds set) are read from the cache,
+// whereas unstructured objects are read directly from the API server.
+// Metadata objects can't be written: their writes fail with an error, before the Cluster's write guards
+// and audit log; write typed or unstructured objects instead, e.g., to patch labels or delete objects.
+// The client forwards calls to the current cache and config, so it keeps working after the Cluster is restarted.
 // TODO: consider implementing Reader, Writer and StatusClient in Cluster
 // and forwarding to actual delegating client.
 func (c *Cluster) GetDelegatingClient() (*client.DelegatingClient, error) {
-	if c.client != nil {
-		return c.client, nil
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+
+	if c.deps.facade != nil {
+		return c.deps.facade, nil
 	}
 
-	ca, err := c.GetCache()
+	// create the actual client to return any error early
+	if _, err := c.getClient(); err != nil {
+		return nil, err
+	}
+
+	cc := &clusterClient{c: c}
+	c.deps.facade = &client.DelegatingClient{
+		Reader:       cc,
+		Writer:       cc,
+		StatusClient: cc,
+	}
+	return c.deps.facade, nil
+}
+
+func (c *Cluster) getClient() (*client.DelegatingClient, error) {
+	if c.deps.client != nil {
+		return c.deps.client, nil
+	}
+
+	ca, err := c.getCache()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := c.GetMapper()
+	m, err := c.getMapper()
 	if err != nil {
 		return nil, err
 	}
 
-	cl, err := client.New(c.Config, client.Options{
+	cfg, err := c.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cl, err := client.New(cfg, client.Options{
 		Scheme: c.GetScheme(),
 		Mapper: m,
 	})
@@ -146,67 +453,49 @@ func (c *Cluster) GetDelegatingClient() (*client.DelegatingClient, error) {
 		return nil, err
 	}
 
+	var clientReader client.Reader = cl
+	if len(c.Namespaces) > 0 {
+		clientReader = cache.RestrictNamespaces(cl, c.Namespaces, c.GetScheme(), m)
+	}
+
 	dc := &client.DelegatingClient{
 		Reader: &client.DelegatingReader{
 			CacheReader:  ca,
-			ClientReader: cl,
+			ClientReader: clientReader,
 		},
 		Writer:       cl,
 		StatusClient: cl,
 	}
 
-	c.client = dc
+	c.deps.client = dc
 	return dc, nil
 }
 
 // AddEventHandler instructs the Cluster's cache to watch objectType's resource,
 // if it doesn't already, and to add handler as an event handler.
+// objectType can be a typed object registered in the Cluster's scheme, an unstructured.Unstructured,
+// or a metav1.PartialObjectMetadata to only cache object metadata.
+// The GroupVersionKinds of unstructured and metadata objects must be set.
+// The event handler is added again to the new cache when the Cluster is restarted.
+// If objectType's resource isn't served by the Cluster's API server (yet), e.g., because its CRD isn't installed,
+// the watch is pending (see PendingWatches) and retried every PendingWatchRetryPeriod once the Cluster is started.
 func (c *Cluster) AddEventHandler(ctx context.Context, objectType runtime.Object, handler clientgocache.ResourceEventHandler) error {
-	ca, err := c.GetCache()
-	if err != nil {
-		return err
-	}
-
-	i, err := ca.GetInformer(ctx, objectType)
-	if err != nil {
-		return err
+	_, errs := c.addToCache(ctx, []eventHandler{{objectType: objectType, handler: handler}})
+	if len(errs) > 0 {
+		return errs[0]
 	}
-
-	i.AddEventHandler(handler)
 	return nil
 }
 
-// Start starts the Cluster's cache and blocks,
-// until an empty struct is sent to the stop channel.
-func (c *Cluster) Start(stop <-chan struct{}) error {
-	ca, err := c.GetCache()
-	if err != nil {
-		return err
-	}
-	return ca.Start(stop)
-}
-
-// WaitForCacheSync waits for the Cluster's cache to sync,
-// OR until an empty struct is sent to the stop channel.
-func (c *Cluster) WaitForCacheSync(stop <-chan struct{}) bool {
-	ca, err := c.GetCache()
-	if err != nil {
-		return false
-	}
-	return ca.WaitForCacheSync(stop)
-}
-
 // CloneWithName creates a new Cluster with the same Kubernetes client, cache, and other cluster-scoped dependencies,
 // but with a different name. This is useful in situations where one cluster is known to other clusters by different
 // names. In particular, this avoids duplicating caches and reduces the load on the Kubernetes API server.
+// The dependencies are shared even if they are lazily created after cloning.
 func (c *Cluster) CloneWithName(name string) *Cluster {
 	return &Cluster{
 		name,
 		c.Config,
-		c.scheme,
-		c.mapper,
-		c.cache,
-		c.client,
+		c.deps,
 		c.Options,
 	}
 }
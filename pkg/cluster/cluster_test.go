@@ -0,0 +1,174 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"admiralty.io/multicluster-controller/pkg/cache"
+)
+
+// newFakeAPIServer serves the discovery endpoints of an API server that only serves core/v1 ConfigMaps,
+// enough to create the informers of a Cluster's cache, which aren't started in these tests.
+func newFakeAPIServer(t *testing.T) *httptest.Server {
+	responses := map[string]interface{}{
+		"/api": &metav1.APIVersions{
+			TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+			Versions: []string{"v1"},
+		},
+		"/apis": &metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+		},
+		"/api/v1": &metav1.APIResourceList{
+			TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{
+				Name:       "configmaps",
+				Namespaced: true,
+				Kind:       "ConfigMap",
+				Verbs:      metav1.Verbs{"get", "list", "watch", "create", "update", "patch", "delete"},
+			}},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			t.Errorf("cannot encode response to %s: %v", r.URL.Path, err)
+		}
+	}))
+}
+
+// TestClonesShareDependencies sets up a Cluster and its clones concurrently, as controllers do,
+// with the getters called in random orders. Run it with -race.
+func TestClonesShareDependencies(t *testing.T) {
+	srv := newFakeAPIServer(t)
+	defer srv.Close()
+
+	for iteration := 0; iteration < 20; iteration++ {
+		c := New("cluster", &rest.Config{Host: srv.URL}, Options{})
+		clusters := []*Cluster{c}
+		for i := 0; i < 4; i++ {
+			clusters = append(clusters, c.CloneWithName(fmt.Sprintf("clone-%d", i)))
+		}
+
+		type result struct {
+			cache  cache.Cache
+			client *client.DelegatingClient
+		}
+		results := make([]result, len(clusters))
+		errs := make(chan error, 3*len(clusters))
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i, cl := range clusters {
+			i, cl := i, cl
+			ops := []func(){
+				func() {
+					ca, err := cl.GetCache()
+					if err != nil {
+						errs <- fmt.Errorf("GetCache of %s: %v", cl.Name, err)
+					}
+					results[i].cache = ca
+				},
+				func() {
+					dc, err := cl.GetDelegatingClient()
+					if err != nil {
+						errs <- fmt.Errorf("GetDelegatingClient of %s: %v", cl.Name, err)
+					}
+					results[i].client = dc
+				},
+				func() {
+					if err := cl.AddEventHandler(context.Background(), &corev1.ConfigMap{}, clientgocache.ResourceEventHandlerFuncs{}); err != nil {
+						errs <- fmt.Errorf("AddEventHandler of %s: %v", cl.Name, err)
+					}
+				},
+			}
+			rand.Shuffle(len(ops), func(i, j int) { ops[i], ops[j] = ops[j], ops[i] })
+			for _, op := range ops {
+				wg.Add(1)
+				go func(op func()) {
+					defer wg.Done()
+					<-start
+					op()
+				}(op)
+			}
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+		for i, r := range results {
+			if r.cache == nil || r.cache != results[0].cache {
+				t.Fatalf("iteration %d: %s has cache %p, %s has %p", iteration, clusters[i].Name, r.cache, clusters[0].Name, results[0].cache)
+			}
+			if r.client == nil || r.client != results[0].client {
+				t.Fatalf("iteration %d: %s has delegating client %p, %s has %p", iteration, clusters[i].Name, r.client, clusters[0].Name, results[0].client)
+			}
+		}
+		if n := len(c.deps.handlers); n != len(clusters) {
+			t.Fatalf("iteration %d: %d event handlers added, %d expected", iteration, n, len(clusters))
+		}
+		if n := len(c.deps.pending); n != 0 {
+			t.Fatalf("iteration %d: %d event handlers pending, none expected", iteration, n)
+		}
+	}
+}
+
+func TestMetadataWritesRejectedBeforePolicy(t *testing.T) {
+	srv := newFakeAPIServer(t)
+	defer srv.Close()
+
+	checked := false
+	c := New("cluster", &rest.Config{Host: srv.URL}, Options{WritePolicy: WritePolicyFunc(func(w Write) error {
+		checked = true
+		return nil
+	})})
+	cl, err := c.GetDelegatingClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"}}
+	m.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+	if err := cl.Delete(context.Background(), m); err == nil {
+		t.Error("got no error deleting a metadata object")
+	}
+	if checked {
+		t.Error("metadata write was checked against the write policy")
+	}
+}
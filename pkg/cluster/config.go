@@ -0,0 +1,253 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigProvider provides up-to-date configs for a Cluster, e.g., with rotated bearer tokens or client certificates.
+type ConfigProvider interface {
+	GetConfig(ctx context.Context) (*rest.Config, error)
+}
+
+// ConfigProviderFunc is a function that implements ConfigProvider.
+type ConfigProviderFunc func(ctx context.Context) (*rest.Config, error)
+
+// GetConfig implements ConfigProvider.
+func (f ConfigProviderFunc) GetConfig(ctx context.Context) (*rest.Config, error) {
+	return f(ctx)
+}
+
+// KubeconfigFile returns a ConfigProvider that reads a kubeconfig file, using the specified context,
+// or the current context if kubeContext is empty.
+func KubeconfigFile(path string, kubeContext string) ConfigProvider {
+	return ConfigProviderFunc(func(ctx context.Context) (*rest.Config, error) {
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+			&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+		).ClientConfig()
+	})
+}
+
+// KubeconfigSecret returns a ConfigProvider that reads a kubeconfig file stored in a Secret,
+// under dataKey ("config" if empty), using the specified context, or the current context if kubeContext is empty.
+// The Secret is read with r, e.g., an uncached client of another cluster; a cache-backed client, e.g., the delegating
+// client of another Cluster, would list and watch all the Secrets it may read.
+func KubeconfigSecret(r client.Reader, key types.NamespacedName, dataKey string, kubeContext string) ConfigProvider {
+	if dataKey == "" {
+		dataKey = "config"
+	}
+	return ConfigProviderFunc(func(ctx context.Context) (*rest.Config, error) {
+		s := &corev1.Secret{}
+		if err := r.Get(ctx, key, s); err != nil {
+			return nil, fmt.Errorf("cannot get kubeconfig secret %s: %v", key, err)
+		}
+		b, ok := s.Data[dataKey]
+		if !ok {
+			return nil, fmt.Errorf("kubeconfig secret %s has no key %s", key, dataKey)
+		}
+		kubeconfig, err := clientcmd.Load(b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubeconfig from secret %s: %v", key, err)
+		}
+		return clientcmd.NewNonInteractiveClientConfig(*kubeconfig, kubeContext, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	})
+}
+
+// configProviderTimeout bounds the calls to ConfigProvider made with the lock held, to get initial configs.
+var configProviderTimeout = 30 * time.Second
+
+// minConfigRefreshInterval limits how often configs are refreshed after authentication failures.
+var minConfigRefreshInterval = 10 * time.Second
+
+// RefreshConfig gets a new config from the Cluster's ConfigProvider and swaps the transport used by the Cluster's
+// clients and informers, so subsequent requests use the new credentials. Informers reconnect with the new credentials
+// when their watches fail. If the API server's address changed, the Cluster is restarted instead (see Restart).
+func (c *Cluster) RefreshConfig(ctx context.Context) error {
+	if c.ConfigProvider == nil {
+		return fmt.Errorf("cluster %s has no config provider", c.Name)
+	}
+
+	c.deps.refreshMu.Lock()
+	c.deps.lastRefresh = time.Now()
+	c.deps.refreshMu.Unlock()
+
+	cfg, err := c.ConfigProvider.GetConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get config of cluster %s: %v", c.Name, err)
+	}
+
+	c.deps.mu.Lock()
+	old := c.deps.config
+	if old != nil && (old.Host != cfg.Host || old.APIPath != cfg.APIPath) {
+		c.deps.mu.Unlock()
+		return c.Restart(cfg)
+	}
+	defer c.deps.mu.Unlock()
+
+	c.deps.config = cfg
+	if c.deps.transport != nil {
+		return c.deps.transport.setConfig(cfg)
+	}
+	return nil
+}
+
+// refreshConfigAfterUnauthorized refreshes the Cluster's config in the background,
+// unless it is already being refreshed or was refreshed recently.
+func (c *Cluster) refreshConfigAfterUnauthorized() {
+	c.deps.refreshMu.Lock()
+	if c.deps.refreshing || time.Since(c.deps.lastRefresh) < minConfigRefreshInterval {
+		c.deps.refreshMu.Unlock()
+		return
+	}
+	c.deps.refreshing = true
+	c.deps.refreshMu.Unlock()
+
+	go func() {
+		defer func() {
+			c.deps.refreshMu.Lock()
+			c.deps.refreshing = false
+			c.deps.refreshMu.Unlock()
+		}()
+		if err := c.RefreshConfig(context.Background()); err != nil {
+			utilruntime.HandleError(fmt.Errorf("cannot refresh config after authentication failure: %v", err))
+		}
+	}()
+}
+
+// refreshConfigPeriodically refreshes the Cluster's config every ConfigRefreshPeriod, until stop is closed.
+func (c *Cluster) refreshConfigPeriodically(stop <-chan struct{}) {
+	t := time.NewTicker(c.ConfigRefreshPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := c.RefreshConfig(context.Background()); err != nil {
+				utilruntime.HandleError(err)
+			}
+		}
+	}
+}
+
+// clientConfig returns the config used to create the Cluster's clients and mapper,
+// throttled by the read and write budgets of the Cluster's ThrottlingOptions.
+// It must be called with the lock held.
+func (c *Cluster) clientConfig() (*rest.Config, error) {
+	cfg, err := c.currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.throttleConfig(cfg, false), nil
+}
+
+// cacheConfig returns the config used to create the Cluster's cache,
+// throttled by the cache budget of the Cluster's ThrottlingOptions.
+// It must be called with the lock held.
+func (c *Cluster) cacheConfig() (*rest.Config, error) {
+	cfg, err := c.currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.throttleConfig(cfg, true), nil
+}
+
+// currentConfig returns the Cluster's current config, without throttling.
+// If the Cluster has a ConfigProvider, the config's transport can be swapped when the config is refreshed,
+// and the initial config is read from the provider if none was given to New.
+// It must be called with the lock held.
+func (c *Cluster) currentConfig() (*rest.Config, error) {
+	if c.ConfigProvider == nil {
+		return c.deps.config, nil
+	}
+
+	if c.deps.config == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), configProviderTimeout)
+		defer cancel()
+		cfg, err := c.ConfigProvider.GetConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get config of cluster %s: %v", c.Name, err)
+		}
+		c.deps.config = cfg
+	}
+
+	if c.deps.transport == nil {
+		t := &rotatingTransport{onUnauthorized: c.refreshConfigAfterUnauthorized}
+		if err := t.setConfig(c.deps.config); err != nil {
+			return nil, err
+		}
+		c.deps.transport = t
+	}
+
+	// The TLS, authentication, and impersonation settings are handled by the rotating transport.
+	cfg := c.deps.config
+	return &rest.Config{
+		Host:          cfg.Host,
+		APIPath:       cfg.APIPath,
+		ContentConfig: cfg.ContentConfig,
+		UserAgent:     cfg.UserAgent,
+		QPS:           cfg.QPS,
+		Burst:         cfg.Burst,
+		RateLimiter:   cfg.RateLimiter,
+		Timeout:       cfg.Timeout,
+		Transport:     c.deps.transport,
+	}, nil
+}
+
+// rotatingTransport forwards requests to a transport that can be swapped when credentials are rotated.
+// It reports authentication failures, so credentials can be refreshed.
+type rotatingTransport struct {
+	mu             sync.RWMutex
+	rt             http.RoundTripper
+	onUnauthorized func()
+}
+
+func (t *rotatingTransport) setConfig(cfg *rest.Config) error {
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.rt = rt
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *rotatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt := t.rt
+	t.mu.RUnlock()
+
+	resp, err := rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && t.onUnauthorized != nil {
+		t.onUnauthorized()
+	}
+	return resp, err
+}
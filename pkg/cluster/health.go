@@ -0,0 +1,225 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+)
+
+// HealthState describes whether a Cluster's API server can be reached.
+type HealthState string
+
+const (
+	// HealthUnknown is the state of a Cluster that hasn't been probed yet.
+	HealthUnknown HealthState = "Unknown"
+	// HealthReady is the state of a Cluster whose last probe succeeded in time.
+	HealthReady HealthState = "Ready"
+	// HealthDegraded is the state of a Cluster whose last probe was slow,
+	// or failed fewer than HealthFailureThreshold times in a row.
+	HealthDegraded HealthState = "Degraded"
+	// HealthUnreachable is the state of a Cluster whose last HealthFailureThreshold probes failed.
+	HealthUnreachable HealthState = "Unreachable"
+)
+
+// Health is the result of the last probe of a Cluster's API server.
+type Health struct {
+	State HealthState
+	// Latency is the duration of the last probe.
+	Latency time.Duration
+	// ConsecutiveFailures is the number of probes that failed since the last successful one.
+	ConsecutiveFailures int
+	// LastProbeTime is when the last probe ended.
+	LastProbeTime time.Time
+	// LastTransitionTime is when State last changed.
+	LastTransitionTime time.Time
+	// LastError is the error of the last probe, if it failed.
+	LastError error
+}
+
+// HealthEvent is sent to the channels returned by WatchHealth when a Cluster's HealthState changes.
+type HealthEvent struct {
+	ClusterName string
+	Previous    HealthState
+	Health
+}
+
+const (
+	defaultHealthProbeTimeout     = 10 * time.Second
+	defaultHealthDegradedLatency  = 2 * time.Second
+	defaultHealthFailureThreshold = 3
+)
+
+// GetHealth returns the Cluster's health, as of its last probe.
+// The state is HealthUnknown if health checks are disabled (see Options.HealthCheckPeriod) or haven't run yet.
+func (c *Cluster) GetHealth() Health {
+	c.deps.healthMu.Lock()
+	defer c.deps.healthMu.Unlock()
+	h := c.deps.health
+	if h.State == "" {
+		h.State = HealthUnknown
+	}
+	return h
+}
+
+// IsReachable returns false if the Cluster is known to be unreachable,
+// so controllers can skip or requeue work for the Cluster instead of failing on connection errors.
+func (c *Cluster) IsReachable() bool {
+	return c.GetHealth().State != HealthUnreachable
+}
+
+// WatchHealth returns a channel that receives a HealthEvent every time the Cluster's HealthState changes,
+// until stop is closed. Events are dropped if the channel's buffer is full, so receivers should keep up,
+// or call GetHealth for the latest state.
+func (c *Cluster) WatchHealth(stop <-chan struct{}) <-chan HealthEvent {
+	ch := make(chan HealthEvent, 10)
+
+	c.deps.healthMu.Lock()
+	c.deps.healthWatchers = append(c.deps.healthWatchers, ch)
+	c.deps.healthMu.Unlock()
+
+	go func() {
+		<-stop
+		c.deps.healthMu.Lock()
+		defer c.deps.healthMu.Unlock()
+		for i, w := range c.deps.healthWatchers {
+			if w == ch {
+				c.deps.healthWatchers = append(c.deps.healthWatchers[:i], c.deps.healthWatchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// ProbeHealth probes the Cluster's API server once, updates its health, and returns it.
+// The readyz endpoint is used, or the healthz endpoint for older API servers.
+func (c *Cluster) ProbeHealth(ctx context.Context) Health {
+	timeout := c.HealthProbeTimeout
+	if timeout == 0 {
+		timeout = defaultHealthProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.probe(ctx)
+	return c.recordProbe(time.Since(start), err)
+}
+
+func (c *Cluster) probe(ctx context.Context) error {
+	c.deps.mu.Lock()
+	cfg, err := c.currentConfig()
+	c.deps.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	rc := dc.RESTClient()
+
+	err = rc.Get().AbsPath("/readyz").Do(ctx).Error()
+	if errors.IsNotFound(err) {
+		err = rc.Get().AbsPath("/healthz").Do(ctx).Error()
+	}
+	if err != nil {
+		return fmt.Errorf("cluster %s is not healthy: %v", c.Name, err)
+	}
+	return nil
+}
+
+func (c *Cluster) recordProbe(latency time.Duration, err error) Health {
+	degradedLatency := c.HealthDegradedLatency
+	if degradedLatency == 0 {
+		degradedLatency = defaultHealthDegradedLatency
+	}
+	failureThreshold := c.HealthFailureThreshold
+	if failureThreshold == 0 {
+		failureThreshold = defaultHealthFailureThreshold
+	}
+
+	c.deps.healthMu.Lock()
+	defer c.deps.healthMu.Unlock()
+
+	h := c.deps.health
+	previous := h.State
+	if previous == "" {
+		previous = HealthUnknown
+	}
+
+	h.Latency = latency
+	h.LastProbeTime = time.Now()
+	h.LastError = err
+	if err != nil {
+		h.ConsecutiveFailures++
+	} else {
+		h.ConsecutiveFailures = 0
+	}
+
+	switch {
+	case h.ConsecutiveFailures >= failureThreshold:
+		h.State = HealthUnreachable
+	case h.ConsecutiveFailures > 0 || latency > degradedLatency:
+		h.State = HealthDegraded
+	default:
+		h.State = HealthReady
+	}
+
+	if h.State != previous {
+		h.LastTransitionTime = h.LastProbeTime
+		e := HealthEvent{ClusterName: c.Name, Previous: previous, Health: h}
+		for _, w := range c.deps.healthWatchers {
+			select {
+			case w <- e:
+			default:
+			}
+		}
+	}
+
+	c.deps.health = h
+	return h
+}
+
+// monitorHealth probes the Cluster's API server every HealthCheckPeriod, until stop is closed.
+func (c *Cluster) monitorHealth(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	t := time.NewTicker(c.HealthCheckPeriod)
+	defer t.Stop()
+	for {
+		c.ProbeHealth(ctx)
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
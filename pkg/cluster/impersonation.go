@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"admiralty.io/multicluster-controller/pkg/cache"
+)
+
+// GetImpersonatingClient returns a client that acts on behalf of the user, groups, and extra fields of imp,
+// e.g., a tenant, so the Cluster's API server authorizes its calls as if they were made by that user.
+// Reads and writes go directly to the API server.
+// The client uses the Cluster's scheme, mapper, and current config (throttling included),
+// keeps working after the Cluster is restarted, and its writes are guarded and audited like the delegating client's.
+func (c *Cluster) GetImpersonatingClient(imp rest.ImpersonationConfig) (*client.DelegatingClient, error) {
+	return c.newImpersonatingClient(imp, false)
+}
+
+// GetImpersonatingDelegatingClient is like GetImpersonatingClient,
+// except that typed and metadata objects are read from the Cluster's cache, like with GetDelegatingClient.
+// Note that cached reads are NOT authorized as the impersonated user; only writes and uncached reads are.
+func (c *Cluster) GetImpersonatingDelegatingClient(imp rest.ImpersonationConfig) (*client.DelegatingClient, error) {
+	return c.newImpersonatingClient(imp, true)
+}
+
+func (c *Cluster) newImpersonatingClient(imp rest.ImpersonationConfig, cacheReads bool) (*client.DelegatingClient, error) {
+	if imp.UserName == "" {
+		return nil, fmt.Errorf("cannot impersonate in cluster %s: user name is required", c.Name)
+	}
+
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+
+	// create the actual client to return any error early
+	if _, err := c.getImpersonatingClient(imp, cacheReads); err != nil {
+		return nil, err
+	}
+
+	cc := &clusterClient{c: c, impersonate: &imp, cacheReads: cacheReads}
+	return &client.DelegatingClient{
+		Reader:       cc,
+		Writer:       cc,
+		StatusClient: cc,
+	}, nil
+}
+
+// getImpersonatingClient returns a lazily created client impersonating imp, for the current generation
+// of the Cluster's dependencies. It must be called with the lock held.
+func (c *Cluster) getImpersonatingClient(imp rest.ImpersonationConfig, cacheReads bool) (client.Client, error) {
+	b, err := json.Marshal(imp) // maps are sorted by key
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%t/%s", cacheReads, b)
+	if cl, ok := c.deps.impersonating[key]; ok {
+		return cl, nil
+	}
+
+	m, err := c.getMapper()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := c.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg = rest.CopyConfig(cfg)
+	cfg.Impersonate = imp
+
+	cl, err := client.New(cfg, client.Options{
+		Scheme: c.GetScheme(),
+		Mapper: m,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reader client.Reader = cl
+	if len(c.Namespaces) > 0 {
+		reader = cache.RestrictNamespaces(cl, c.Namespaces, c.GetScheme(), m)
+	}
+	if cacheReads {
+		ca, err := c.getCache()
+		if err != nil {
+			return nil, err
+		}
+		reader = &client.DelegatingReader{
+			CacheReader:  ca,
+			ClientReader: reader,
+		}
+	}
+
+	dc := &client.DelegatingClient{
+		Reader:       reader,
+		Writer:       cl,
+		StatusClient: cl,
+	}
+
+	if c.deps.impersonating == nil {
+		c.deps.impersonating = make(map[string]client.Client)
+	}
+	c.deps.impersonating[key] = dc
+	return dc, nil
+}
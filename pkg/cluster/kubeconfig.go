@@ -0,0 +1,214 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeconfigSource keeps a ClusterSet in sync with the contexts of a kubeconfig file, e.g., for local development
+// and small fleets: it adds a Cluster per context, named after the context, and watches the file to add,
+// remove, or reconfigure Clusters live. Reconfigured Clusters are restarted with their new configs (see Restart),
+// so their watches carry on, unless their namespaces change, in which case they're replaced (and stopped).
+// Removed Clusters are stopped.
+type KubeconfigSource struct {
+	// Path is the path of the kubeconfig file. If empty, the files listed in the KUBECONFIG environment variable
+	// are merged, or ~/.kube/config is used, as by kubectl. Relative paths in the files, e.g., of certificates,
+	// are resolved relative to the files.
+	Path string
+	// Contexts are the contexts to create Clusters for. If empty, all the contexts of the file are used.
+	// The name of the current context can be given as an empty string.
+	Contexts []string
+	// Set receives the Clusters.
+	Set *ClusterSet
+	// ClusterOptions are the options of the Clusters.
+	ClusterOptions Options
+	// UseContextNamespaces, if true, restricts the caches of the Clusters to the namespaces of their contexts.
+	UseContextNamespaces bool
+	// PollInterval is the interval between checks for changes of the file. Defaults to 2 seconds.
+	PollInterval time.Duration
+
+	mu       sync.Mutex
+	raw      []byte                       // paths and contents of the files, see readKubeconfigFiles
+	contexts map[string]kubeconfigContext // by cluster name
+}
+
+// kubeconfigContext is what a Cluster was built from.
+type kubeconfigContext struct {
+	context   *clientcmdapi.Context
+	cluster   *clientcmdapi.Cluster
+	authInfo  *clientcmdapi.AuthInfo
+	namespace string
+}
+
+// Load reads the kubeconfig file, and adds, removes, or reconfigures the Clusters of the Set accordingly.
+// It is called by Start, but can be called beforehand to set up watches on the initial Clusters.
+func (s *KubeconfigSource) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: s.Path}
+	if s.Path == "" {
+		rules = clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.MigrationRules = nil // not to write files
+	}
+	path := strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator))
+
+	raw, err := readKubeconfigFiles(rules)
+	if err != nil {
+		return err
+	}
+	if s.contexts != nil && bytes.Equal(raw, s.raw) {
+		return nil
+	}
+
+	// Unlike clientcmd.Load, the loading rules merge the files, and resolve the paths in the files,
+	// e.g., of certificates, relative to the files.
+	kubeconfig, err := rules.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load kubeconfig file %s: %v", path, err)
+	}
+
+	names := s.Contexts
+	if len(names) == 0 {
+		for name := range kubeconfig.Contexts {
+			names = append(names, name)
+		}
+	}
+
+	contexts := make(map[string]kubeconfigContext, len(names))
+	var errs []error
+	for _, name := range names {
+		if name == "" {
+			name = kubeconfig.CurrentContext
+		}
+		kc, ok := kubeconfig.Contexts[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("context %s not found in kubeconfig file %s", name, path))
+			continue
+		}
+		contexts[name] = kubeconfigContext{
+			context:   kc,
+			cluster:   kubeconfig.Clusters[kc.Cluster],
+			authInfo:  kubeconfig.AuthInfos[kc.AuthInfo],
+			namespace: kc.Namespace,
+		}
+
+		old, ok := s.contexts[name]
+		if ok && reflect.DeepEqual(old, contexts[name]) {
+			continue
+		}
+
+		cfg, err := clientcmd.NewNonInteractiveClientConfig(*kubeconfig, name, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot get config for context %s: %v", name, err))
+			if ok {
+				contexts[name] = old // keep the current Cluster
+			} else {
+				delete(contexts, name)
+			}
+			continue
+		}
+
+		c := s.Set.Get(name)
+		if ok && c != nil && (!s.UseContextNamespaces || old.namespace == kc.Namespace) {
+			if err := c.Restart(cfg); err != nil {
+				errs = append(errs, fmt.Errorf("cannot restart cluster %s: %v", name, err))
+			}
+			continue
+		}
+		if c != nil {
+			c.Stop()
+		}
+
+		o := s.ClusterOptions
+		if s.UseContextNamespaces {
+			o.Namespace = kc.Namespace
+		}
+		s.Set.Add(New(name, cfg, o))
+	}
+
+	for name := range s.contexts {
+		if _, ok := contexts[name]; ok {
+			continue
+		}
+		if c := s.Set.Get(name); c != nil {
+			c.Stop()
+			s.Set.Remove(name)
+		}
+	}
+
+	s.raw = raw
+	s.contexts = contexts
+	return utilerrors.NewAggregate(errs)
+}
+
+// readKubeconfigFiles returns the paths and contents of the kubeconfig files of rules, to detect changes.
+// Missing files are skipped, as by the loading rules, unless the path is explicit.
+func readKubeconfigFiles(rules *clientcmd.ClientConfigLoadingRules) ([]byte, error) {
+	var b bytes.Buffer
+	for _, path := range rules.GetLoadingPrecedence() {
+		raw, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) && rules.ExplicitPath == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read kubeconfig file %s: %v", path, err)
+		}
+		fmt.Fprintf(&b, "%s\x00%d\x00", path, len(raw))
+		b.Write(raw)
+	}
+	return b.Bytes(), nil
+}
+
+// Start loads the kubeconfig file, then checks it for changes every PollInterval, until stop is closed.
+// Errors are reported with utilruntime.HandleError, except the initial one, which is returned.
+func (s *KubeconfigSource) Start(stop <-chan struct{}) error {
+	if err := s.Load(); err != nil {
+		return err
+	}
+
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-t.C:
+			if err := s.Load(); err != nil {
+				utilruntime.HandleError(err)
+			}
+		}
+	}
+}
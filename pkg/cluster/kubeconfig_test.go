@@ -0,0 +1,104 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://%[1]s.example.com
+    certificate-authority: certs/ca.crt
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[1]s
+users:
+- name: %[1]s
+  user:
+    token: token
+`
+
+func TestKubeconfigSourceMergesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name, "config")
+		if err := os.MkdirAll(filepath.Join(dir, name, "certs"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		// The certificate is only read when the config is validated, not parsed.
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "certs", "ca.crt"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(fmt.Sprintf(testKubeconfig, name)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	old, set := os.LookupEnv("KUBECONFIG")
+	os.Setenv("KUBECONFIG", strings.Join(paths, string(filepath.ListSeparator)))
+	defer func() {
+		if set {
+			os.Setenv("KUBECONFIG", old)
+		} else {
+			os.Unsetenv("KUBECONFIG")
+		}
+	}()
+
+	s := &KubeconfigSource{Set: NewClusterSet()}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		c := s.Set.Get(name)
+		if c == nil {
+			t.Fatalf("no cluster for context %s", name)
+		}
+		expected := filepath.Join(dir, name, "certs", "ca.crt")
+		if f := c.Config.TLSClientConfig.CAFile; f != expected {
+			t.Errorf("got CA file %s for context %s, expected %s", f, name, expected)
+		}
+	}
+
+	raw := s.raw
+	if err := ioutil.WriteFile(paths[1], []byte(fmt.Sprintf(testKubeconfig, "c")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(raw, s.raw) || s.Set.Get("b") != nil || s.Set.Get("c") == nil {
+		t.Error("change of the second file wasn't detected")
+	}
+}
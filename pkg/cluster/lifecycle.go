@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+
+	"admiralty.io/multicluster-controller/pkg/cache"
+)
+
+// Start starts the Cluster's cache and blocks,
+// until an empty struct is sent to the stop channel.
+// The cache is shared with the Cluster's clones and only started once;
+// subsequent calls just block until stop is closed.
+// The cache can be stopped earlier with Stop, and replaced with Restart, without closing stop.
+func (c *Cluster) Start(stop <-chan struct{}) error {
+	c.deps.mu.Lock()
+	if c.deps.started {
+		c.deps.mu.Unlock()
+		<-stop
+		return nil
+	}
+	ca, err := c.getCache()
+	if err != nil {
+		c.deps.mu.Unlock()
+		return err
+	}
+	c.deps.started = true
+	c.deps.stop = stop
+	if !c.deps.stopped {
+		c.startCache(ca)
+	}
+	c.deps.mu.Unlock()
+
+	if c.ConfigProvider != nil && c.ConfigRefreshPeriod > 0 {
+		go c.refreshConfigPeriodically(stop)
+	}
+	go c.retryPendingWatches(stop)
+	go c.discoverClusterUIDUntilKnown(stop)
+	if c.HealthCheckPeriod > 0 {
+		go c.monitorHealth(stop)
+	}
+
+	<-stop
+
+	c.deps.mu.Lock()
+	c.stopCache()
+	c.deps.mu.Unlock()
+	return nil
+}
+
+// WaitForCacheSync waits for the Cluster's cache to sync,
+// OR until an empty struct is sent to the stop channel.
+// If the Cluster is restarted, the new cache is waited for.
+func (c *Cluster) WaitForCacheSync(stop <-chan struct{}) bool {
+	ca, err := c.GetCache()
+	if err != nil {
+		return false
+	}
+	return ca.WaitForCacheSync(stop)
+}
+
+// Stop stops the Cluster's cache, e.g., when its credentials have expired, without affecting other Clusters.
+// Until the Cluster is restarted, calls to its delegating client fail with an error for which IsStoppedErr is true.
+func (c *Cluster) Stop() {
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+
+	c.deps.stopped = true
+	c.deps.lifecycle++
+	c.stopCache()
+}
+
+// Restart stops the Cluster's cache if it isn't already stopped, then replaces the mapper, cache, and client
+// with fresh ones, created from config (if not nil, e.g., with rotated credentials or a new endpoint)
+// or from the current config. The event handlers added with AddEventHandler are added to the new cache,
+// which is started if the Cluster was started. Clients returned by GetDelegatingClient use the new cache and config.
+// The event handlers that can't be added to the new cache, e.g., because their resources aren't served anymore,
+// are pending (see PendingWatches). If Restart fails, the Cluster remains stopped.
+func (c *Cluster) Restart(config *rest.Config) error {
+	c.deps.mu.Lock()
+	c.stopCache()
+	c.deps.stopped = true
+	c.deps.lifecycle++
+	lifecycle := c.deps.lifecycle
+
+	if config != nil {
+		c.deps.config = config
+		if c.deps.transport != nil {
+			if err := c.deps.transport.setConfig(config); err != nil {
+				c.deps.mu.Unlock()
+				return fmt.Errorf("cannot create transport from new config: %v", err)
+			}
+		}
+	}
+	c.deps.mapper = nil
+	c.deps.cache = nil
+	c.deps.client = nil
+	c.deps.impersonating = nil
+
+	if _, err := c.getCache(); err != nil {
+		c.deps.mu.Unlock()
+		return fmt.Errorf("cannot create new cache: %v", err)
+	}
+	hs := c.deps.handlers
+	c.deps.handlers = nil
+	pending := c.deps.pending
+	c.deps.pending = nil
+	c.deps.mu.Unlock()
+
+	// The informers of the new cache are got without holding the lock, see addToCache.
+	failed, errs := c.addToCache(context.Background(), append(hs, pending...))
+	for _, err := range errs {
+		utilruntime.HandleError(fmt.Errorf("cannot add event handler to new cache of cluster %s: %v", c.Name, err))
+	}
+
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+	c.deps.pending = append(c.deps.pending, failed...)
+	if c.deps.lifecycle != lifecycle {
+		// Stopped or restarted again meanwhile.
+		return nil
+	}
+	c.deps.stopped = false
+
+	if c.deps.started {
+		select {
+		case <-c.deps.stop:
+		default:
+			c.startCache(c.deps.cache)
+		}
+	}
+	return nil
+}
+
+// startCache starts ca until the current cache is stopped. It must be called with the lock held.
+func (c *Cluster) startCache(ca cache.Cache) {
+	cacheStop := make(chan struct{})
+	c.deps.cacheStop = cacheStop
+	go func() {
+		if err := ca.Start(cacheStop); err != nil {
+			utilruntime.HandleError(fmt.Errorf("cache of cluster %s: %v", c.Name, err))
+		}
+	}()
+}
+
+// stopCache stops the current cache, if started. It must be called with the lock held.
+func (c *Cluster) stopCache() {
+	if c.deps.cacheStop != nil {
+		close(c.deps.cacheStop)
+		c.deps.cacheStop = nil
+	}
+}
+
+type stoppedErr struct {
+	s string
+}
+
+func (e *stoppedErr) Error() string {
+	return e.s
+}
+
+// IsStoppedErr returns true if err was returned because a Cluster was stopped.
+func IsStoppedErr(err error) bool {
+	_, ok := err.(*stoppedErr)
+	return ok
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"admiralty.io/multicluster-controller/pkg/cache"
+)
+
+const defaultPendingWatchRetryPeriod = 30 * time.Second
+
+// isNotInstalled returns true if err was returned because a resource isn't (yet) served by the Cluster's API server,
+// e.g., because its CRD isn't installed, or because the RESTMapper couldn't rediscover the API yet.
+func isNotInstalled(err error) bool {
+	if meta.IsNoMatchError(err) {
+		return true
+	}
+	_, ok := apiutil.DelayIfRateLimited(err)
+	return ok
+}
+
+// PendingWatches returns the GroupVersionKinds of the resources that the Cluster was asked to watch with
+// AddEventHandler, but that its API server doesn't serve yet, e.g., because their CRDs aren't installed.
+// Pending watches start automatically once the resources are served.
+func (c *Cluster) PendingWatches() []schema.GroupVersionKind {
+	c.deps.mu.Lock()
+	defer c.deps.mu.Unlock()
+
+	s := c.GetScheme()
+	var gvks []schema.GroupVersionKind
+	seen := make(map[schema.GroupVersionKind]bool)
+	for _, h := range c.deps.pending {
+		gvk, err := cache.GVKForObject(h.objectType, s)
+		if err != nil || seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+		gvks = append(gvks, gvk)
+	}
+	return gvks
+}
+
+// addToCache adds the event handlers hs to the informers of the Cluster's current cache, then records them
+// as added, or as pending if their resources aren't served yet. The lock isn't held while the informers are got,
+// because that may require API discovery, which would block the Cluster's other users; if the cache is replaced
+// meanwhile (see Restart), the handlers are added to the new cache instead.
+// The handlers that fail otherwise aren't recorded; they're returned with their errors.
+func (c *Cluster) addToCache(ctx context.Context, hs []eventHandler) ([]eventHandler, []error) {
+	for {
+		c.deps.mu.Lock()
+		ca, err := c.getCache()
+		c.deps.mu.Unlock()
+		if err != nil {
+			errs := make([]error, len(hs))
+			for i := range errs {
+				errs[i] = err
+			}
+			return hs, errs
+		}
+
+		var added, pending, failed []eventHandler
+		var errs []error
+		for _, h := range hs {
+			i, err := ca.GetInformer(ctx, h.objectType)
+			if isNotInstalled(err) {
+				pending = append(pending, h)
+				continue
+			}
+			if err != nil {
+				failed = append(failed, h)
+				errs = append(errs, err)
+				continue
+			}
+			i.AddEventHandler(h.handler)
+			added = append(added, h)
+		}
+
+		c.deps.mu.Lock()
+		if c.deps.cache != ca {
+			c.deps.mu.Unlock()
+			continue
+		}
+		c.deps.handlers = append(c.deps.handlers, added...)
+		c.deps.pending = append(c.deps.pending, pending...)
+		c.deps.mu.Unlock()
+		return failed, errs
+	}
+}
+
+// startPendingWatches tries to start the pending watches. It must be called without the lock held.
+// The watches that fail for other reasons than their resources not being served stay pending.
+func (c *Cluster) startPendingWatches(ctx context.Context) {
+	c.deps.mu.Lock()
+	if len(c.deps.pending) == 0 || c.deps.stopped {
+		c.deps.mu.Unlock()
+		return
+	}
+	hs := c.deps.pending
+	c.deps.pending = nil
+	c.deps.mu.Unlock()
+
+	failed, errs := c.addToCache(ctx, hs)
+	for _, err := range errs {
+		utilruntime.HandleError(fmt.Errorf("cannot start pending watch in cluster %s: %v", c.Name, err))
+	}
+
+	c.deps.mu.Lock()
+	c.deps.pending = append(c.deps.pending, failed...)
+	c.deps.mu.Unlock()
+}
+
+// retryPendingWatches tries to start the pending watches every PendingWatchRetryPeriod, until stop is closed.
+func (c *Cluster) retryPendingWatches(stop <-chan struct{}) {
+	period := c.PendingWatchRetryPeriod
+	if period == 0 {
+		period = defaultPendingWatchRetryPeriod
+	}
+
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.startPendingWatches(context.Background())
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	"admiralty.io/multicluster-controller/pkg/audit"
+	"admiralty.io/multicluster-controller/pkg/cache"
+)
+
+// Write describes a call to a write method of a Cluster's client, e.g., to be allowed or denied by a WritePolicy.
+type Write struct {
+	ClusterName string
+	// Verb is create, update, patch, delete, or deletecollection (for DeleteAllOf).
+	Verb string
+	// Subresource is status for writes through the status client, or empty.
+	Subresource      string
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	// Name is empty for deletecollection, and may be empty for create, if the object has a generated name.
+	Name   string
+	Object runtime.Object
+	// ImpersonatedUser is the user on behalf of whom the write is made, if any (see GetImpersonatingClient).
+	ImpersonatedUser string
+}
+
+func (w Write) String() string {
+	verb := w.Verb
+	if w.Subresource != "" {
+		verb += " " + w.Subresource + " of"
+	}
+	obj := w.GroupVersionKind.Kind
+	if w.Name != "" {
+		obj += " " + w.Name
+	}
+	if w.Namespace != "" {
+		obj += " in namespace " + w.Namespace
+	}
+	if w.ImpersonatedUser != "" {
+		return fmt.Sprintf("%s %s in cluster %s as %s", verb, obj, w.ClusterName, w.ImpersonatedUser)
+	}
+	return fmt.Sprintf("%s %s in cluster %s", verb, obj, w.ClusterName)
+}
+
+// WritePolicy allows or denies writes to a Cluster, so a bug in a reconciler can't mutate objects it shouldn't.
+type WritePolicy interface {
+	// AllowWrite returns an error if w is denied.
+	AllowWrite(w Write) error
+}
+
+// WritePolicyFunc is a function that implements WritePolicy.
+type WritePolicyFunc func(w Write) error
+
+// AllowWrite implements WritePolicy.
+func (f WritePolicyFunc) AllowWrite(w Write) error {
+	return f(w)
+}
+
+// AllowList is a WritePolicy that only allows writes in some namespaces and/or of some kinds.
+type AllowList struct {
+	// Namespaces, if not empty, are the only namespaces where namespaced objects can be written.
+	// Cluster-scoped objects can still be written, if their kinds are allowed.
+	Namespaces []string
+	// GroupVersionKinds, if not empty, are the only kinds of objects that can be written.
+	GroupVersionKinds []schema.GroupVersionKind
+}
+
+// AllowWrite implements WritePolicy.
+func (l AllowList) AllowWrite(w Write) error {
+	if len(l.GroupVersionKinds) > 0 && !containsGVK(l.GroupVersionKinds, w.GroupVersionKind) {
+		return fmt.Errorf("kind %s is not allowed", w.GroupVersionKind)
+	}
+	if len(l.Namespaces) > 0 && w.Namespace != "" && !containsString(l.Namespaces, w.Namespace) {
+		return fmt.Errorf("namespace %s is not allowed", w.Namespace)
+	}
+	return nil
+}
+
+func containsGVK(gvks []schema.GroupVersionKind, gvk schema.GroupVersionKind) bool {
+	for _, g := range gvks {
+		if g == gvk {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newWrite describes a write of obj, or of objects of obj's kind in namespace for deletecollection.
+func (c *Cluster) newWrite(verb, subresource string, obj runtime.Object, namespace string) Write {
+	w := Write{ClusterName: c.Name, Verb: verb, Subresource: subresource, Namespace: namespace, Object: obj}
+	if gvk, err := cache.GVKForObject(obj, c.GetScheme()); err == nil {
+		w.GroupVersionKind = gvk
+	}
+	if verb != "deletecollection" {
+		if o, err := meta.Accessor(obj); err == nil {
+			w.Namespace = o.GetNamespace()
+			w.Name = o.GetName()
+		}
+	}
+	return w
+}
+
+// checkWrite returns an error if the Cluster is read-only, or if its WritePolicy denies w.
+func (c *Cluster) checkWrite(w Write) error {
+	if c.ReadOnly {
+		return &readOnlyErr{s: fmt.Sprintf("cannot %s: cluster %s is read-only", w, c.Name)}
+	}
+	if c.WritePolicy != nil {
+		if err := c.WritePolicy.AllowWrite(w); err != nil {
+			return &writeDeniedErr{s: fmt.Sprintf("cannot %s: %v", w, err)}
+		}
+	}
+	return nil
+}
+
+// audit records w and its result with the Cluster's AuditSink, if any.
+func (c *Cluster) audit(ctx context.Context, w Write, err error) {
+	if c.AuditSink == nil {
+		return
+	}
+	e := audit.Entry{
+		Time:        time.Now(),
+		Cluster:     w.ClusterName,
+		Verb:        w.Verb,
+		Subresource: w.Subresource,
+		APIVersion:  w.GroupVersionKind.GroupVersion().String(),
+		Kind:        w.GroupVersionKind.Kind,
+		Namespace:   w.Namespace,
+		Name:        w.Name,
+
+		ImpersonatedUser: w.ImpersonatedUser,
+	}
+	e.SetSource(ctx)
+	if err != nil {
+		e.Error = err.Error()
+	} else if w.Verb != "deletecollection" {
+		if o, err := meta.Accessor(w.Object); err == nil {
+			e.Name = o.GetName() // may have been generated
+			e.ResourceVersion = o.GetResourceVersion()
+		}
+	}
+	if err := c.AuditSink.Record(e); err != nil {
+		utilruntime.HandleError(fmt.Errorf("cannot record %s in audit log: %v", w, err))
+	}
+}
+
+type readOnlyErr struct {
+	s string
+}
+
+func (e *readOnlyErr) Error() string {
+	return e.s
+}
+
+// IsReadOnlyErr returns true if err was returned by a write to a read-only Cluster.
+func IsReadOnlyErr(err error) bool {
+	_, ok := err.(*readOnlyErr)
+	return ok
+}
+
+type writeDeniedErr struct {
+	s string
+}
+
+func (e *writeDeniedErr) Error() string {
+	return e.s
+}
+
+// IsWriteDeniedErr returns true if err was returned by a write denied by a Cluster's WritePolicy.
+func IsWriteDeniedErr(err error) bool {
+	_, ok := err.(*writeDeniedErr)
+	return ok
+}
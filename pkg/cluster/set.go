@@ -0,0 +1,189 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ClusterSetHandler is notified when Clusters join or leave a ClusterSet.
+type ClusterSetHandler interface {
+	OnAdd(c *Cluster)
+	OnRemove(c *Cluster)
+}
+
+// ClusterSetHandlerFuncs is an adaptor to let you easily specify as many or as few of the notification functions
+// as you want while still implementing ClusterSetHandler.
+type ClusterSetHandlerFuncs struct {
+	AddFunc    func(c *Cluster)
+	RemoveFunc func(c *Cluster)
+}
+
+// OnAdd calls AddFunc if it's not nil.
+func (f ClusterSetHandlerFuncs) OnAdd(c *Cluster) {
+	if f.AddFunc != nil {
+		f.AddFunc(c)
+	}
+}
+
+// OnRemove calls RemoveFunc if it's not nil.
+func (f ClusterSetHandlerFuncs) OnRemove(c *Cluster) {
+	if f.RemoveFunc != nil {
+		f.RemoveFunc(c)
+	}
+}
+
+// ClusterSet is a dynamic set of Clusters, indexed by name. Clusters can be added and removed at any time.
+// A ClusterSet can be filtered by the Clusters' labels with Select, e.g., to target all the clusters with env=prod,
+// including clusters that join (or are updated to match) later. ClusterSets are safe for concurrent use.
+type ClusterSet struct {
+	mu       sync.Mutex
+	clusters map[string]*Cluster
+	handlers []ClusterSetHandler
+
+	// parent and selector are set if the ClusterSet was created by Select
+	parent   *ClusterSet
+	selector labels.Selector
+}
+
+// NewClusterSet creates a ClusterSet with the given Clusters.
+func NewClusterSet(clusters ...*Cluster) *ClusterSet {
+	s := &ClusterSet{clusters: make(map[string]*Cluster, len(clusters))}
+	for _, c := range clusters {
+		s.clusters[c.Name] = c
+	}
+	return s
+}
+
+// Select returns a ClusterSet of the Clusters of s whose labels match selector.
+// It is kept in sync with s: Clusters join it when they're added to s with matching labels,
+// and leave it when they're removed from s or updated with labels that don't match anymore.
+// Adding a Cluster to (or removing a Cluster from) the returned ClusterSet adds it to (or removes it from) s.
+// A nil selector matches all the Clusters, like labels.Everything().
+func (s *ClusterSet) Select(selector labels.Selector) *ClusterSet {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	sub := &ClusterSet{clusters: make(map[string]*Cluster), parent: s, selector: selector}
+	s.AddHandler(ClusterSetHandlerFuncs{
+		AddFunc: func(c *Cluster) {
+			if selector.Matches(labels.Set(c.Labels)) {
+				sub.add(c)
+			} else {
+				sub.remove(c.Name)
+			}
+		},
+		RemoveFunc: func(c *Cluster) {
+			sub.remove(c.Name)
+		},
+	})
+	return sub
+}
+
+// Add adds c to the ClusterSet, replacing any Cluster with the same name, e.g., to update its labels.
+// Handlers are notified that the replaced Cluster (if any) was removed, and that c was added.
+func (s *ClusterSet) Add(c *Cluster) {
+	if s.parent != nil {
+		s.parent.Add(c)
+		return
+	}
+	s.add(c)
+}
+
+// Remove removes the Cluster named name from the ClusterSet, if any.
+func (s *ClusterSet) Remove(name string) {
+	if s.parent != nil {
+		s.parent.Remove(name)
+		return
+	}
+	s.remove(name)
+}
+
+func (s *ClusterSet) add(c *Cluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.clusters[c.Name]; ok {
+		if old == c {
+			return
+		}
+		for _, h := range s.handlers {
+			h.OnRemove(old)
+		}
+	}
+	s.clusters[c.Name] = c
+	for _, h := range s.handlers {
+		h.OnAdd(c)
+	}
+}
+
+func (s *ClusterSet) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.clusters[name]
+	if !ok {
+		return
+	}
+	delete(s.clusters, name)
+	for _, h := range s.handlers {
+		h.OnRemove(c)
+	}
+}
+
+// Get returns the Cluster named name, or nil if it isn't in the ClusterSet.
+func (s *ClusterSet) Get(name string) *Cluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clusters[name]
+}
+
+// List returns the Clusters in the ClusterSet, sorted by name.
+func (s *ClusterSet) List() []*Cluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clusters := make([]*Cluster, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+	return clusters
+}
+
+// AddHandler adds a handler that is notified when Clusters join or leave the ClusterSet.
+// The handler is first notified of the Clusters already in the ClusterSet, in alphabetical order.
+// Handlers are called synchronously, in order, while the ClusterSet is locked, so they must not call its methods.
+func (s *ClusterSet) AddHandler(h ClusterSetHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.clusters))
+	for name := range s.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.OnAdd(s.clusters[name])
+	}
+	s.handlers = append(s.handlers, h)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"net/http"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// ThrottlingOptions limit the rate of requests to a Cluster's API server, from all the controllers sharing the Cluster
+// (and its clones), so a fleet-wide controller can't overwhelm a small cluster. Each budget is a token bucket
+// with a QPS and a burst. A request must fit in the overall budget and in the budget of its kind.
+// The overall budget is unlimited if its QPS is zero. The kind budgets whose QPS is zero default to the rate limit
+// of the Cluster's rest.Config: its RateLimiter, or its QPS and Burst (client-go's defaults, 5 and 10, if zero),
+// unless its QPS is negative; unset read and write budgets are shared by the clients, and the cache has its own.
+// When any budget is set, the rest.Config's rate limit only applies through these budgets, not per REST client.
+// Health probes (see ProbeHealth) aren't throttled.
+type ThrottlingOptions struct {
+	// QPS and Burst limit all requests.
+	QPS   float32
+	Burst int
+	// ReadQPS and ReadBurst limit the reads (GET requests) of clients, e.g., delegating clients' uncached reads,
+	// and discovery.
+	ReadQPS   float32
+	ReadBurst int
+	// WriteQPS and WriteBurst limit the writes (other requests) of clients.
+	WriteQPS   float32
+	WriteBurst int
+	// CacheQPS and CacheBurst limit the lists and watches of the Cluster's cache, e.g., when informers relist,
+	// so they can't starve controllers of their read budget, and vice versa.
+	CacheQPS   float32
+	CacheBurst int
+}
+
+func (o ThrottlingOptions) enabled() bool {
+	return o.QPS > 0 || o.ReadQPS > 0 || o.WriteQPS > 0 || o.CacheQPS > 0
+}
+
+// throttle holds a Cluster's rate limiters. A nil limiter is unlimited.
+type throttle struct {
+	all, read, write, cache flowcontrol.RateLimiter
+}
+
+// newThrottle creates the rate limiters of o, with the rate limit of cfg for the unset kind budgets.
+func newThrottle(o ThrottlingOptions, cfg *rest.Config) *throttle {
+	t := &throttle{
+		all:   newRateLimiter(o.QPS, o.Burst),
+		read:  newRateLimiter(o.ReadQPS, o.ReadBurst),
+		write: newRateLimiter(o.WriteQPS, o.WriteBurst),
+		cache: newRateLimiter(o.CacheQPS, o.CacheBurst),
+	}
+	if t.read == nil || t.write == nil {
+		clients := configRateLimiter(cfg)
+		if t.read == nil {
+			t.read = clients
+		}
+		if t.write == nil {
+			t.write = clients
+		}
+	}
+	if t.cache == nil {
+		t.cache = configRateLimiter(cfg)
+	}
+	return t
+}
+
+// configRateLimiter returns the rate limiter of cfg, or a new one from its QPS and Burst, defaulted like client-go's,
+// or nil if its QPS is negative, i.e., rate limiting is disabled.
+func configRateLimiter(cfg *rest.Config) flowcontrol.RateLimiter {
+	if cfg.RateLimiter != nil {
+		return cfg.RateLimiter
+	}
+	if cfg.QPS < 0 {
+		return nil
+	}
+	qps, burst := cfg.QPS, cfg.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+}
+
+func newRateLimiter(qps float32, burst int) flowcontrol.RateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+}
+
+// throttleConfig returns a copy of cfg whose requests are throttled by the Cluster's rate limiters,
+// using the cache budget if forCache is true, or the read and write budgets otherwise.
+// The rate limiters are created from the first config, so later configs' rate limits are ignored.
+// It must be called with the lock held.
+func (c *Cluster) throttleConfig(cfg *rest.Config, forCache bool) *rest.Config {
+	if !c.Throttling.enabled() {
+		return cfg
+	}
+	if c.deps.throttle == nil {
+		c.deps.throttle = newThrottle(c.Throttling, cfg)
+	}
+	t := c.deps.throttle
+
+	cfg = rest.CopyConfig(cfg)
+	cfg.QPS = -1 // disables the client-side rate limiter of each REST client
+	cfg.RateLimiter = nil
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		tr := &throttlingTransport{rt: rt, all: t.all}
+		if forCache {
+			tr.read, tr.write = t.cache, t.cache
+		} else {
+			tr.read, tr.write = t.read, t.write
+		}
+		return tr
+	})
+	return cfg
+}
+
+// throttlingTransport waits for tokens from its rate limiters before forwarding requests.
+type throttlingTransport struct {
+	rt               http.RoundTripper
+	all, read, write flowcontrol.RateLimiter
+}
+
+func (t *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind := t.write
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		kind = t.read
+	}
+	for _, l := range []flowcontrol.RateLimiter{t.all, kind} {
+		if l == nil {
+			continue
+		}
+		if err := l.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// WrappedRoundTripper allows client-go to cancel requests through the throttling transport.
+func (t *throttlingTransport) WrappedRoundTripper() http.RoundTripper {
+	return t.rt
+}
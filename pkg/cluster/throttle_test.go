@@ -0,0 +1,62 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"testing"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestNewThrottleFallsBackToConfig(t *testing.T) {
+	th := newThrottle(ThrottlingOptions{CacheQPS: 20, CacheBurst: 40}, &rest.Config{})
+	if th.all != nil {
+		t.Error("overall budget isn't unlimited")
+	}
+	if th.read == nil || th.read != th.write {
+		t.Fatal("unset read and write budgets don't share a rate limiter")
+	}
+	if qps := th.read.QPS(); qps != rest.DefaultQPS {
+		t.Errorf("got client QPS %v, expected client-go's default %v", qps, rest.DefaultQPS)
+	}
+	if qps := th.cache.QPS(); qps != 20 {
+		t.Errorf("got cache QPS %v, expected 20", qps)
+	}
+
+	th = newThrottle(ThrottlingOptions{ReadQPS: 20}, &rest.Config{QPS: 50, Burst: 100})
+	if qps := th.read.QPS(); qps != 20 {
+		t.Errorf("got read QPS %v, expected 20", qps)
+	}
+	if qps := th.write.QPS(); qps != 50 {
+		t.Errorf("got write QPS %v, expected the config's 50", qps)
+	}
+	if th.cache == nil || th.cache == th.write {
+		t.Error("unset cache budget doesn't have its own rate limiter")
+	}
+
+	l := flowcontrol.NewTokenBucketRateLimiter(1, 1)
+	th = newThrottle(ThrottlingOptions{QPS: 20}, &rest.Config{RateLimiter: l})
+	if th.read != l || th.write != l || th.cache != l {
+		t.Error("unset kind budgets don't use the config's rate limiter")
+	}
+
+	th = newThrottle(ThrottlingOptions{QPS: 20}, &rest.Config{QPS: -1})
+	if th.read != nil || th.write != nil || th.cache != nil {
+		t.Error("unset kind budgets aren't unlimited when the config disables rate limiting")
+	}
+}
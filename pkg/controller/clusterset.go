@@ -0,0 +1,293 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	"admiralty.io/multicluster-controller/pkg/cluster"
+)
+
+// WatchClusterSetReconcileObject is like WatchResourceReconcileObject, for all the Clusters of set,
+// including the Clusters that join set later. See WatchClusterSet.
+func (c *Controller) WatchClusterSetReconcileObject(ctx context.Context, set *cluster.ClusterSet, objectType runtime.Object, o WatchOptions) error {
+	return c.WatchClusterSet(ctx, set, objectType, func(cl Cluster) (cache.ResourceEventHandler, error) {
+		return c.newObjectHandler(cl, objectType, o, cl.GetClusterName())
+	})
+}
+
+// WatchClusterSetReconcileController is like WatchResourceReconcileController, for all the Clusters of set,
+// including the Clusters that join set later. See WatchClusterSet.
+func (c *Controller) WatchClusterSetReconcileController(ctx context.Context, set *cluster.ClusterSet, objectType runtime.Object, o WatchOptions) error {
+	return c.WatchClusterSet(ctx, set, objectType, func(cl Cluster) (cache.ResourceEventHandler, error) {
+		return c.newControllerHandler(cl, o), nil
+	})
+}
+
+// WatchClusterSet configures the Controller to watch resources of the same Kind as objectType,
+// in all the Clusters of set, e.g., a ClusterSet selected by labels, with event handlers created by newHandler.
+// When a Cluster joins set, the watch is added, and the Cluster's cache is started if the Controller is started.
+// When a Cluster leaves set, its event handler is disabled (informers can't remove event handlers),
+// and unless the Controller watches the Cluster otherwise, it is forgotten: Requests whose context is its name
+// are dropped from the queue. When it joins again, a new event handler is added, which receives Add events
+// for all the cached objects, so the objects that changed meanwhile are reconciled. The Cluster's cache isn't stopped, as it may be shared; see cluster.Cluster's Stop.
+// The errors of watches added for Clusters already in set are returned; watches of Clusters joining later
+// are added in the background, and their errors are logged. Failed watches are retried every JoinRetryPeriod
+// while the Controller is started, until they succeed or their Clusters leave set.
+func (c *Controller) WatchClusterSet(ctx context.Context, set *cluster.ClusterSet, objectType runtime.Object, newHandler func(cl Cluster) (cache.ResourceEventHandler, error)) error {
+	w := &clusterSetWatch{ctx: ctx, objectType: objectType, newHandler: newHandler}
+
+	var mu sync.Mutex
+	var err error
+	initializing := true
+
+	set.AddHandler(cluster.ClusterSetHandlerFuncs{
+		AddFunc: func(cl *cluster.Cluster) {
+			key := toggleKey{cluster: cl, watch: w}
+			c.addJoining(key)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if initializing {
+				if joinErr := c.join(key); joinErr != nil && err == nil {
+					err = joinErr
+				}
+				return
+			}
+			// Not to block the ClusterSet (and the Clusters joining it) while the watch is added.
+			go func() {
+				if joinErr := c.join(key); joinErr != nil {
+					c.Logger.Printf("cannot watch cluster %s joining cluster set: %v", cl.Name, joinErr)
+				}
+			}()
+		},
+		RemoveFunc: func(cl *cluster.Cluster) {
+			c.leave(cl, w)
+		},
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	initializing = false
+	return err
+}
+
+// clusterSetWatch is a call to WatchClusterSet.
+type clusterSetWatch struct {
+	ctx        context.Context
+	objectType runtime.Object
+	newHandler func(cl Cluster) (cache.ResourceEventHandler, error)
+}
+
+type toggleKey struct {
+	cluster Cluster
+	watch   *clusterSetWatch
+}
+
+// addJoining records that the cluster of key joined the ClusterSet of key's watch,
+// and that an event handler must be added.
+func (c *Controller) addJoining(key toggleKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.joining[key] = false
+}
+
+// join adds a new event handler of key's watch to key's cluster, unless the cluster left the ClusterSet
+// or an attempt is already in progress. The lock isn't held while the event handler is added,
+// because it may require API discovery in the cluster, which would block the control loops.
+// If it fails, the cluster stays in joining, to be retried (see retryJoins).
+func (c *Controller) join(key toggleKey) error {
+	c.mu.Lock()
+	attempting, ok := c.joining[key]
+	if !ok || attempting {
+		c.mu.Unlock()
+		return nil
+	}
+	c.joining[key] = true
+	c.mu.Unlock()
+
+	t, err := c.addToggle(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.joining[key]; !ok {
+		// The cluster left meanwhile.
+		if t != nil {
+			t.setEnabled(false)
+		}
+		return nil
+	}
+	if err != nil {
+		c.joining[key] = false
+		return err
+	}
+	delete(c.joining, key)
+
+	if _, ok := c.toggles[key]; ok {
+		// The cluster left and joined again meanwhile, and another attempt won.
+		t.setEnabled(false)
+		return nil
+	}
+	c.toggles[key] = t
+
+	cl := key.cluster
+	_, known := c.clusters[cl]
+	c.clusters[cl] = struct{}{}
+	delete(c.removed, cl.GetClusterName())
+	if !known && c.stop != nil {
+		go c.startCluster(cl)
+	}
+	return nil
+}
+
+// addToggle adds a new event handler of key's watch to key's cluster, wrapped in an enabled toggleHandler.
+func (c *Controller) addToggle(key toggleKey) (*toggleHandler, error) {
+	h, err := key.watch.newHandler(key.cluster)
+	if err != nil {
+		return nil, err
+	}
+	// Enabled beforehand, not to miss the objects that started informers replay to new handlers.
+	t := &toggleHandler{h: h}
+	t.setEnabled(true)
+	if err := key.cluster.AddEventHandler(key.watch.ctx, key.watch.objectType, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// retryJoins retries to add the event handlers of clusters that joined watched ClusterSets, whose previous attempts failed.
+func (c *Controller) retryJoins() {
+	c.mu.Lock()
+	keys := make([]toggleKey, 0, len(c.joining))
+	for key, attempting := range c.joining {
+		if !attempting {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		if err := c.join(key); err != nil {
+			c.Logger.Printf("cannot watch cluster %s of cluster set: %v", key.cluster.GetClusterName(), err)
+		}
+	}
+}
+
+// leave disables w's event handler of cl, and forgets cl if the Controller doesn't watch it otherwise.
+// The disabled handler is dropped (though informers keep it), so if cl joins again, a new handler is added,
+// to which the informer replays the cached objects, including those that changed while cl was out.
+func (c *Controller) leave(cl Cluster, w *clusterSetWatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := toggleKey{cluster: cl, watch: w}
+	delete(c.joining, key)
+	if t, ok := c.toggles[key]; ok {
+		t.setEnabled(false)
+		delete(c.toggles, key)
+	}
+
+	if _, ok := c.static[cl]; ok {
+		return
+	}
+	for k := range c.toggles {
+		if k.cluster == cl {
+			return
+		}
+	}
+	for k := range c.joining {
+		if k.cluster == cl {
+			return
+		}
+	}
+	delete(c.clusters, cl)
+	c.removed[cl.GetClusterName()] = true
+}
+
+// joinedClusters returns the clusters that joined watched ClusterSets and haven't left them.
+// It must be called with the lock held.
+func (c *Controller) joinedClusters() []Cluster {
+	var cls []Cluster
+	seen := make(map[Cluster]bool)
+	for k, t := range c.toggles {
+		if !seen[k.cluster] && t.isEnabled() {
+			seen[k.cluster] = true
+			cls = append(cls, k.cluster)
+		}
+	}
+	return cls
+}
+
+// startCluster starts the cache of a cluster that joined a watched ClusterSet, until the Controller is stopped.
+// Clusters whose caches are already started, e.g., by the Manager, just block.
+// Clusters outside the Controller's shard aren't started; a sharded Manager starts them if they join the shard.
+func (c *Controller) startCluster(cl Cluster) {
+	if s := c.getShard(); s != nil && !s.NeedsCache(cl.GetClusterName()) {
+		return
+	}
+	if err := cl.Start(c.stop); err != nil {
+		c.Logger.Printf("cannot start cache of cluster %s: %v", cl.GetClusterName(), err)
+	}
+}
+
+// isRemoved returns true if the cluster named clusterName left the watched ClusterSets and isn't watched anymore.
+func (c *Controller) isRemoved(clusterName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removed[clusterName]
+}
+
+// toggleHandler forwards events to an event handler while enabled.
+type toggleHandler struct {
+	enabled int32
+	h       cache.ResourceEventHandler
+}
+
+func (t *toggleHandler) setEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&t.enabled, v)
+}
+
+func (t *toggleHandler) isEnabled() bool {
+	return atomic.LoadInt32(&t.enabled) == 1
+}
+
+func (t *toggleHandler) OnAdd(obj interface{}) {
+	if t.isEnabled() {
+		t.h.OnAdd(obj)
+	}
+}
+
+func (t *toggleHandler) OnUpdate(oldObj, newObj interface{}) {
+	if t.isEnabled() {
+		t.h.OnUpdate(oldObj, newObj)
+	}
+}
+
+func (t *toggleHandler) OnDelete(obj interface{}) {
+	if t.isEnabled() {
+		t.h.OnDelete(obj)
+	}
+}
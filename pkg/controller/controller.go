@@ -19,17 +19,25 @@ package controller // import "admiralty.io/multicluster-controller/pkg/controlle
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
+	mccache "admiralty.io/multicluster-controller/pkg/cache"
 	"admiralty.io/multicluster-controller/pkg/handler"
 	"admiralty.io/multicluster-controller/pkg/manager"
 	"admiralty.io/multicluster-controller/pkg/reconcile"
@@ -41,14 +49,32 @@ import (
 // A Controller can watch multiple resources in multiple clusters. It saves those clusters in a set,
 // so the Manager knows which caches to start and sync before starting the Controller.
 type Controller struct {
+	panics int64 // recovered panics, accessed atomically, first for 64-bit alignment
+
 	reconciler reconcile.Reconciler
-	clusters   manager.CacheSet
+
+	mu       sync.Mutex
+	clusters manager.CacheSet
+	static   manager.CacheSet             // clusters watched with WatchResource, as opposed to through ClusterSets
+	toggles  map[toggleKey]*toggleHandler // event handlers of ClusterSet watches, by cluster and watch
+	joining  map[toggleKey]bool           // ClusterSet watches not added yet, true while an attempt is in progress
+	removed  map[string]bool              // names of clusters that left the watched ClusterSets
+	stop     <-chan struct{}              // closed after draining, to start the caches of clusters joining ClusterSets
+	shard    manager.Shard                // clusters to process Requests for, if restricted by a sharded Manager
+
+	drainMu   sync.Mutex
+	draining  bool                            // true once stopped
+	inFlight  map[reconcile.Request]struct{}  // Requests being reconciled
+	delayed   map[reconcile.Request]time.Time // Requests requeued for later, with the earliest times they're due
+	abandoned map[reconcile.Request]struct{}  // Requests left unprocessed by shutdown
+
 	Options
 }
 
 // Options is used as an argument of New.
 type Options struct {
-	// JitterPeriod is the time to wait after an error to start working again.
+	// JitterPeriod is the time to wait before restarting a control loop that exited.
+	// Control loops don't exit on reconcile errors or recovered panics: the Requests are requeued.
 	JitterPeriod time.Duration
 	// MaxConcurrentReconciles is the number of concurrent control loops.
 	// Use this if your Reconciler is slow, but thread safe.
@@ -57,11 +83,31 @@ type Options struct {
 	Queue workqueue.RateLimitingInterface
 	// Logger can be used to override the default logger.
 	Logger *log.Logger
+	// UnreachableRequeueAfter is the time to wait before retrying a Request
+	// whose cluster is known to be unreachable (see cluster.Cluster's IsReachable), instead of reconciling it.
+	// Defaults to 10 seconds.
+	UnreachableRequeueAfter time.Duration
+	// ShutdownTimeout is the maximum time to wait for in-flight reconciles to finish when the Controller is stopped.
+	// Requests still in flight after that, like Requests still queued, and Requests requeued for later
+	// by the Controller (e.g., with a Result's RequeueAfter), are abandoned (see AbandonedRequests).
+	// Requests added for later to the Queue directly, outside of the Controller, aren't tracked, hence not reported.
+	// Defaults to 30 seconds.
+	ShutdownTimeout time.Duration
+	// CrashOnPanic, if true, lets panics in the Reconciler crash the process, e.g., for debugging,
+	// after logging them. By default, panics are recovered, logged, counted (see PanicCount),
+	// and the Requests are requeued as if they had failed.
+	CrashOnPanic bool
+	// JoinRetryPeriod is the time to wait before retrying to watch the clusters that joined watched ClusterSets
+	// (see WatchClusterSet) when the watches failed, e.g., because the clusters were unreachable.
+	// Defaults to 30 seconds.
+	JoinRetryPeriod time.Duration
 }
 
 // Cluster decouples the controller package from the cluster package.
 type Cluster interface {
 	GetClusterName() string
+	GetClusterUID() (types.UID, error)
+	GetScheme() *runtime.Scheme
 	AddEventHandler(context.Context, runtime.Object, cache.ResourceEventHandler) error
 	manager.Cache
 }
@@ -71,6 +117,13 @@ func New(r reconcile.Reconciler, o Options) *Controller {
 	c := &Controller{
 		reconciler: r,
 		clusters:   make(manager.CacheSet),
+		static:     make(manager.CacheSet),
+		toggles:    make(map[toggleKey]*toggleHandler),
+		joining:    make(map[toggleKey]bool),
+		removed:    make(map[string]bool),
+		inFlight:   make(map[reconcile.Request]struct{}),
+		delayed:    make(map[reconcile.Request]time.Time),
+		abandoned:  make(map[reconcile.Request]struct{}),
 		Options:    o,
 	}
 
@@ -90,6 +143,18 @@ func New(r reconcile.Reconciler, o Options) *Controller {
 		c.Logger = log.New(os.Stdout, "", log.Lshortfile)
 	}
 
+	if c.UnreachableRequeueAfter == 0 {
+		c.UnreachableRequeueAfter = 10 * time.Second
+	}
+
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 30 * time.Second
+	}
+
+	if c.JoinRetryPeriod == 0 {
+		c.JoinRetryPeriod = 30 * time.Second
+	}
+
 	return c
 }
 
@@ -145,56 +210,192 @@ func (o WatchOptions) Predicate(obj interface{}) bool {
 
 // WatchResourceReconcileObject configures the Controller to watch resources of the same Kind as objectType,
 // in the specified cluster, generating reconcile Requests from the Cluster's context
-// and the watched objects' namespaces and names.
+// and the watched objects' namespaces and names. The Requests' GroupVersionKind is objectType's.
 func (c *Controller) WatchResourceReconcileObject(ctx context.Context, cluster Cluster, objectType runtime.Object, o WatchOptions) error {
-	h := &handler.EnqueueRequestForObject{Context: cluster.GetClusterName(), Queue: c.Queue, Predicate: o.Predicate}
-	return c.WatchResource(ctx, cluster, objectType, h)
+	return c.WatchResourceReconcileObjectOverrideContext(ctx, cluster, objectType, o, cluster.GetClusterName())
 }
 
 // WatchResourceReconcileObjectOverrideContext configures the Controller to watch resources of the same Kind as objectType,
 // in the specified cluster, generating reconcile Requests from the watched objects' namespaces and names
 // with the specified context override. This is useful when you want to reuse a Cluster with different names.
 func (c *Controller) WatchResourceReconcileObjectOverrideContext(ctx context.Context, cluster Cluster, objectType runtime.Object, o WatchOptions, contextOverride string) error {
-	h := &handler.EnqueueRequestForObject{Context: contextOverride, Queue: c.Queue, Predicate: o.Predicate}
+	h, err := c.newObjectHandler(cluster, objectType, o, contextOverride)
+	if err != nil {
+		return err
+	}
 	return c.WatchResource(ctx, cluster, objectType, h)
 }
 
+func (c *Controller) newObjectHandler(cluster Cluster, objectType runtime.Object, o WatchOptions, context string) (cache.ResourceEventHandler, error) {
+	gvk, err := mccache.GVKForObject(objectType, cluster.GetScheme())
+	if err != nil {
+		return nil, fmt.Errorf("getting GVK for object type: %v", err)
+	}
+	return &handler.EnqueueRequestForObject{Context: context, Queue: c.Queue, Predicate: o.Predicate, GroupVersionKind: gvk}, nil
+}
+
 // WatchResourceReconcileController configures the Controller to watch resources of the same Kind as objectType,
 // in the specified cluster, generating reconcile Requests from the Cluster's context
 // and the namespaces and names of the watched objects' controller references.
+// The Requests' GroupVersionKind is the controllers' (from the controller references).
+// The cluster UIDs of multicluster controller references are resolved to the names of the clusters watched by the Controller,
+// so the Requests' contexts match the names under which the Controller knows the clusters, whatever names were used
+// by the controllers that set the references.
 func (c *Controller) WatchResourceReconcileController(ctx context.Context, cluster Cluster, objectType runtime.Object, o WatchOptions) error {
-	h := &handler.EnqueueRequestForController{Context: cluster.GetClusterName(), Queue: c.Queue, Predicate: o.Predicate}
-	return c.WatchResource(ctx, cluster, objectType, h)
+	return c.WatchResource(ctx, cluster, objectType, c.newControllerHandler(cluster, o))
+}
+
+func (c *Controller) newControllerHandler(cluster Cluster, o WatchOptions) cache.ResourceEventHandler {
+	return &handler.EnqueueRequestForController{Context: cluster.GetClusterName(), Queue: c.Queue, Predicate: o.Predicate, Logger: c.Logger,
+		ResolveClusterName: c.resolveClusterName}
+}
+
+// resolveClusterName returns the name of the cluster watched by the Controller whose UID is clusterUID.
+// If several watched clusters have that UID (e.g., clones), clusterName is preferred, then the first name in alphabetical order.
+// If none does, clusterName is returned. It is called by event handlers, so it doesn't wait for UID discoveries:
+// clusters are only matched once their UIDs are known (see cluster.Cluster's KnownClusterUID),
+// which they discover in the background when started.
+func (c *Controller) resolveClusterName(clusterUID types.UID, clusterName string) string {
+	found := ""
+	for ca := range c.GetCaches() {
+		cl, ok := ca.(Cluster)
+		if !ok {
+			continue
+		}
+		var uid types.UID
+		if k, ok := cl.(interface{ KnownClusterUID() (types.UID, bool) }); ok {
+			var known bool
+			if uid, known = k.KnownClusterUID(); !known {
+				continue
+			}
+		} else {
+			var err error
+			if uid, err = cl.GetClusterUID(); err != nil {
+				c.Logger.Printf("cannot get UID of cluster %s: %v", cl.GetClusterName(), err)
+				continue
+			}
+		}
+		if uid != clusterUID {
+			continue
+		}
+		name := cl.GetClusterName()
+		if name == clusterName {
+			return name
+		}
+		if found == "" || name < found {
+			found = name
+		}
+	}
+	if found == "" {
+		return clusterName
+	}
+	return found
+}
+
+// isReachable returns false if a watched cluster named clusterName is known to be unreachable.
+// Clusters that don't monitor their health are assumed to be reachable.
+func (c *Controller) isReachable(clusterName string) bool {
+	for ca := range c.GetCaches() {
+		cl, ok := ca.(Cluster)
+		if !ok || cl.GetClusterName() != clusterName {
+			continue
+		}
+		if r, ok := cl.(interface{ IsReachable() bool }); ok && !r.IsReachable() {
+			return false
+		}
+	}
+	return true
 }
 
 // WatchResource configures the Controller to watch resources of the same Kind as objectType,
 // in the specified cluster, generating reconcile Requests an arbitrary ResourceEventHandler.
 func (c *Controller) WatchResource(ctx context.Context, cluster Cluster, objectType runtime.Object, h cache.ResourceEventHandler) error {
+	c.mu.Lock()
 	c.clusters[cluster] = struct{}{}
+	c.static[cluster] = struct{}{}
+	delete(c.removed, cluster.GetClusterName())
+	c.mu.Unlock()
 	return cluster.AddEventHandler(ctx, objectType, h)
 }
 
 // TODO: watch channel
 
+// PendingWatch is a watch of a resource that isn't served yet by a cluster's API server,
+// e.g., because its CRD isn't installed yet. See cluster.Cluster's PendingWatches.
+type PendingWatch struct {
+	ClusterName      string
+	GroupVersionKind schema.GroupVersionKind
+}
+
+// PendingWatches returns the pending watches of the clusters watched by the Controller,
+// sorted by cluster name, so readiness checks can report which resources aren't watched yet, and where.
+func (c *Controller) PendingWatches() []PendingWatch {
+	var pws []PendingWatch
+	for ca := range c.GetCaches() {
+		cl, ok := ca.(interface {
+			GetClusterName() string
+			PendingWatches() []schema.GroupVersionKind
+		})
+		if !ok {
+			continue
+		}
+		for _, gvk := range cl.PendingWatches() {
+			pws = append(pws, PendingWatch{ClusterName: cl.GetClusterName(), GroupVersionKind: gvk})
+		}
+	}
+	sort.Slice(pws, func(i, j int) bool {
+		if pws[i].ClusterName != pws[j].ClusterName {
+			return pws[i].ClusterName < pws[j].ClusterName
+		}
+		return pws[i].GroupVersionKind.String() < pws[j].GroupVersionKind.String()
+	})
+	return pws
+}
+
 // GetCaches gets the current set of clusters (which implement manager.Cache) watched by the Controller.
 // Manager uses this to ensure the necessary caches are started and synced before it starts the Controller.
+// The Controller starts the caches of the clusters that join watched ClusterSets later.
 func (c *Controller) GetCaches() manager.CacheSet {
-	return c.clusters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs := make(manager.CacheSet, len(c.clusters))
+	for ca := range c.clusters {
+		cs[ca] = struct{}{}
+	}
+	return cs
 }
 
 // Start starts the Controller's control loops (as many as MaxConcurrentReconciles) in separate channels
-// and blocks until an empty struct is sent to the stop channel.
+// and blocks until an empty struct is sent to the stop channel. Start then shuts down gracefully:
+// it stops accepting new work, and waits for in-flight reconciles to finish, up to ShutdownTimeout,
+// before stopping the caches of the clusters that joined watched ClusterSets and returning.
 func (c *Controller) Start(stop <-chan struct{}) error {
-	defer c.Queue.ShutDown()
+	clusterStop := make(chan struct{})
+	defer close(clusterStop)
 
+	c.mu.Lock()
+	c.stop = clusterStop
+	for _, cl := range c.joinedClusters() {
+		go c.startCluster(cl)
+	}
+	c.mu.Unlock()
+
+	go wait.Until(c.retryJoins, c.JoinRetryPeriod, clusterStop)
+
+	var workers sync.WaitGroup
 	for i := 0; i < c.MaxConcurrentReconciles; i++ {
-		go wait.Until(func() {
-			for c.processNextWorkItem() {
-			}
-		}, c.JitterPeriod, stop)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(func() {
+				for c.processNextWorkItem() {
+				}
+			}, c.JitterPeriod, stop)
+		}()
 	}
 
 	<-stop
+	c.shutDown(&workers)
 	return nil
 }
 
@@ -218,13 +419,38 @@ func (c *Controller) processNextWorkItem() bool {
 		return true
 	}
 
-	if result, err := c.reconciler.Reconcile(req); err != nil {
+	c.clearDelayed(req)
+
+	if c.isDraining() {
+		c.abandon(req)
+		c.Queue.Forget(obj)
+		return true
+	}
+
+	if c.isRemoved(req.Context) || !c.ownsCluster(req.Context) {
+		c.Queue.Forget(obj)
+		return true
+	}
+
+	if !c.isReachable(req.Context) {
+		c.addAfter(req, c.UnreachableRequeueAfter)
+		return true
+	}
+
+	c.setInFlight(req, true)
+	result, err := c.safeReconcile(req)
+	c.setInFlight(req, false)
+	if c.isDraining() && (err != nil || result.RequeueAfter > 0 || result.Requeue) {
+		c.abandon(req) // the queue doesn't accept requeues anymore
+	}
+
+	if err != nil {
 		c.Logger.Print(err)
-		c.Logger.Print("Could not reconcile Request. Stop working.")
+		c.Logger.Printf("Could not reconcile Request %v. Requeue it. Next.", req)
 		c.Queue.AddRateLimited(req)
-		return false
+		return true
 	} else if result.RequeueAfter > 0 {
-		c.Queue.AddAfter(req, result.RequeueAfter)
+		c.addAfter(req, result.RequeueAfter)
 		return true
 	} else if result.Requeue {
 		c.Queue.AddRateLimited(req)
@@ -234,3 +460,24 @@ func (c *Controller) processNextWorkItem() bool {
 	c.Queue.Forget(obj)
 	return true
 }
+
+// safeReconcile calls the Reconciler, recovering from and counting its panics, which are returned as errors,
+// unless CrashOnPanic is true.
+func (c *Controller) safeReconcile(req reconcile.Request) (result reconcile.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&c.panics, 1)
+			c.Logger.Printf("Panic while reconciling Request %v: %v\n%s", req, r, debug.Stack())
+			if c.CrashOnPanic {
+				panic(r)
+			}
+			err = fmt.Errorf("recovered from panic: %v", r)
+		}
+	}()
+	return c.reconciler.Reconcile(req)
+}
+
+// PanicCount returns the number of panics recovered while reconciling Requests since the Controller was created.
+func (c *Controller) PanicCount() int64 {
+	return atomic.LoadInt64(&c.panics)
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"admiralty.io/multicluster-controller/pkg/manager"
+)
+
+// SetShard restricts the Controller to the clusters of s: Requests whose context isn't owned by s are dropped,
+// and the caches of clusters joining watched ClusterSets are only started if s needs them.
+// It is called by sharded Managers (see manager.NewSharded) before starting the Controller.
+func (c *Controller) SetShard(s manager.Shard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shard = s
+}
+
+func (c *Controller) getShard() manager.Shard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shard
+}
+
+// ownsCluster returns true if the Controller isn't restricted to a shard, or if its shard owns clusterName.
+func (c *Controller) ownsCluster(clusterName string) bool {
+	s := c.getShard()
+	return s == nil || s.OwnsCluster(clusterName)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"admiralty.io/multicluster-controller/pkg/reconcile"
+)
+
+// shutDown stops the Controller from accepting new work, and waits up to ShutdownTimeout for the workers
+// to finish their in-flight reconciles. Requests still queued or requeued for later,
+// and Requests still in flight after the timeout, are abandoned, and reported.
+func (c *Controller) shutDown(workers *sync.WaitGroup) {
+	c.drainMu.Lock()
+	c.draining = true
+	c.drainMu.Unlock()
+	c.Queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(c.ShutdownTimeout):
+		c.drainMu.Lock()
+		for req := range c.inFlight {
+			c.abandoned[req] = struct{}{}
+		}
+		c.drainMu.Unlock()
+	}
+
+	// Requests requeued for later won't be added to the shut-down queue.
+	c.drainMu.Lock()
+	for req := range c.delayed {
+		c.abandoned[req] = struct{}{}
+	}
+	c.drainMu.Unlock()
+
+	// Workers may have exited, or be stuck, before emptying the queue.
+	// Get doesn't block once the queue is shut down.
+	for c.Queue.Len() > 0 {
+		obj, shutdown := c.Queue.Get()
+		if shutdown {
+			break
+		}
+		if req, ok := obj.(reconcile.Request); ok {
+			c.abandon(req)
+		}
+		c.Queue.Forget(obj)
+		c.Queue.Done(obj)
+	}
+
+	if abandoned := c.AbandonedRequests(); len(abandoned) > 0 {
+		c.Logger.Printf("Shut down. Abandoned %d Requests: %v", len(abandoned), abandoned)
+	}
+}
+
+// AbandonedRequests returns the Requests left unprocessed when the Controller was stopped:
+// Requests still queued, or requeued for later by the Controller, Requests still being reconciled after ShutdownTimeout,
+// and Requests that should have been requeued. They are sorted by context, namespace, name, and GroupVersionKind.
+func (c *Controller) AbandonedRequests() []reconcile.Request {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	abandoned := make([]reconcile.Request, 0, len(c.abandoned))
+	for req := range c.abandoned {
+		abandoned = append(abandoned, req)
+	}
+	sort.Slice(abandoned, func(i, j int) bool {
+		a, b := abandoned[i], abandoned[j]
+		if a.Context != b.Context {
+			return a.Context < b.Context
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		ag, bg := a.GroupVersionKind, b.GroupVersionKind
+		if ag.Group != bg.Group {
+			return ag.Group < bg.Group
+		}
+		if ag.Version != bg.Version {
+			return ag.Version < bg.Version
+		}
+		return ag.Kind < bg.Kind
+	})
+	return abandoned
+}
+
+func (c *Controller) isDraining() bool {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	return c.draining
+}
+
+func (c *Controller) abandon(req reconcile.Request) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	c.abandoned[req] = struct{}{}
+}
+
+func (c *Controller) setInFlight(req reconcile.Request, inFlight bool) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if inFlight {
+		c.inFlight[req] = struct{}{}
+	} else {
+		delete(c.inFlight, req)
+	}
+}
+
+// addAfter adds req to the queue after d, and tracks it until it's processed, so it's reported if abandoned.
+func (c *Controller) addAfter(req reconcile.Request, d time.Duration) {
+	due := time.Now().Add(d)
+	c.drainMu.Lock()
+	// The queue keeps the earliest time a Request is due.
+	if t, ok := c.delayed[req]; !ok || due.Before(t) {
+		c.delayed[req] = due
+	}
+	c.drainMu.Unlock()
+	c.Queue.AddAfter(req, d)
+}
+
+// clearDelayed stops tracking req if it was requeued for later and is due, i.e., it's being processed.
+// Requests processed earlier, e.g., after watch events, remain requeued for later.
+func (c *Controller) clearDelayed(req reconcile.Request) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if t, ok := c.delayed[req]; ok && !time.Now().Before(t) {
+		delete(c.delayed, req)
+	}
+}
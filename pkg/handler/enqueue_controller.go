@@ -17,8 +17,12 @@ limitations under the License.
 package handler // import "admiralty.io/multicluster-controller/pkg/handler"
 
 import (
+	"log"
+
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 
 	"admiralty.io/multicluster-controller/pkg/reconcile"
 	"admiralty.io/multicluster-controller/pkg/reference"
@@ -29,6 +33,13 @@ type EnqueueRequestForController struct {
 	ControllerContext string
 	Queue             Queue
 	Predicate         func(obj interface{}) bool
+	// Logger is used to report invalid multicluster controller references.
+	// If nil, the standard logger is used.
+	Logger *log.Logger
+	// ResolveClusterName, if set, returns the name under which the controller knows the cluster
+	// identified by the cluster UID of a multicluster controller reference.
+	// The reference's cluster name is passed as a hint, and returned if the cluster UID is unknown.
+	ResolveClusterName func(clusterUID types.UID, clusterName string) string
 }
 
 func (e *EnqueueRequestForController) enqueue(obj interface{}) {
@@ -43,7 +54,7 @@ func (e *EnqueueRequestForController) enqueue(obj interface{}) {
 
 	// First, try to get a controller reference in the same cluster.
 	if c := metav1.GetControllerOf(o); c != nil {
-		r := reconcile.Request{Context: e.Context}
+		r := reconcile.Request{Context: e.Context, GroupVersionKind: schema.FromAPIVersionAndKind(c.APIVersion, c.Kind)}
 		r.Namespace = o.GetNamespace()
 		r.Name = c.Name
 
@@ -52,11 +63,20 @@ func (e *EnqueueRequestForController) enqueue(obj interface{}) {
 	}
 
 	// Then, try to get a multicluster controller reference.
-	if c := reference.GetMulticlusterControllerOf(o); c != nil {
-		if e.ControllerContext != "" && c.ClusterName != e.ControllerContext {
+	c, err := reference.GetMulticlusterControllerOf(o)
+	if err != nil {
+		e.logf("Ignoring multicluster controller reference of %s in namespace %s in cluster %s: %v", o.GetName(), o.GetNamespace(), e.Context, err)
+		return
+	}
+	if c != nil {
+		clusterName := c.ClusterName
+		if c.ClusterUID != "" && e.ResolveClusterName != nil {
+			clusterName = e.ResolveClusterName(c.ClusterUID, c.ClusterName)
+		}
+		if e.ControllerContext != "" && clusterName != e.ControllerContext {
 			return
 		}
-		r := reconcile.Request{Context: c.ClusterName}
+		r := reconcile.Request{Context: clusterName, GroupVersionKind: schema.FromAPIVersionAndKind(c.APIVersion, c.Kind)}
 		r.Namespace = c.Namespace
 		r.Name = c.Name
 
@@ -65,6 +85,14 @@ func (e *EnqueueRequestForController) enqueue(obj interface{}) {
 	}
 }
 
+func (e *EnqueueRequestForController) logf(format string, v ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
 func (e *EnqueueRequestForController) OnAdd(obj interface{}) {
 	e.enqueue(obj)
 }
@@ -18,6 +18,7 @@ package handler // import "admiralty.io/multicluster-controller/pkg/handler"
 
 import (
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"admiralty.io/multicluster-controller/pkg/reconcile"
 )
@@ -26,6 +27,8 @@ type EnqueueRequestForObject struct {
 	Context   string
 	Queue     Queue
 	Predicate func(obj interface{}) bool
+	// GroupVersionKind is copied into the Requests, usually the GVK of the watched prototype.
+	GroupVersionKind schema.GroupVersionKind
 }
 
 func (e *EnqueueRequestForObject) enqueue(obj interface{}) {
@@ -38,7 +41,7 @@ func (e *EnqueueRequestForObject) enqueue(obj interface{}) {
 		return
 	}
 
-	r := reconcile.Request{Context: e.Context}
+	r := reconcile.Request{Context: e.Context, GroupVersionKind: e.GroupVersionKind}
 	r.Namespace = o.GetNamespace()
 	r.Name = o.GetName()
 
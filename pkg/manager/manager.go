@@ -28,8 +28,15 @@ type ControllerSet map[Controller]struct{}
 type CacheSet map[Cache]struct{}
 
 // Manager manages controllers. It starts their caches, waits for those to sync, then starts the controllers.
+// A sharded Manager (see NewSharded) only starts the caches of its shard of clusters.
 type Manager struct {
 	controllers ControllerSet
+
+	sharding     *ShardingOptions
+	unsharded    map[string]bool
+	mu           sync.RWMutex
+	ring         *ring    // live replicas, if sharded
+	shardStopped CacheSet // caches stopped because their clusters left the shard
 }
 
 // New creates a Manager.
@@ -56,9 +63,27 @@ func (m *Manager) AddController(c Controller) {
 
 // Start gets all the unique caches of the controllers it manages, starts them,
 // then starts the controllers as soon as their respective caches are synced.
-// Start blocks until an error or stop is received.
+// If the Manager is sharded, only the caches of its shard are started, see NewSharded.
+// Start blocks until an error or stop is received. On stop, it waits for the controllers to shut down,
+// e.g., to finish their in-flight reconciles, before stopping the caches and returning.
 func (m *Manager) Start(stop <-chan struct{}) error {
+	cacheStop := make(chan struct{})
+	if m.sharding != nil {
+		return m.startSharded(stop, cacheStop)
+	}
+	return m.start(stop, cacheStop)
+}
+
+// start starts the caches until cacheStop is closed, and the controllers until stop is closed.
+// cacheStop is closed once stop is closed and the controllers have returned, even if start returns early with an error.
+func (m *Manager) start(stop <-chan struct{}, cacheStop chan struct{}) error {
 	errCh := make(chan error)
+	report := func(err error) {
+		select {
+		case errCh <- err:
+		case <-stop:
+		}
+	}
 
 	wgs := make(map[Controller]*sync.WaitGroup)
 	caches := make(map[Cache]ControllerSet)
@@ -66,6 +91,9 @@ func (m *Manager) Start(stop <-chan struct{}) error {
 	for co := range m.controllers {
 		wgs[co] = &sync.WaitGroup{}
 		for ca := range co.GetCaches() {
+			if !m.needsCacheOf(ca) {
+				continue
+			}
 			wgs[co].Add(1)
 			cos, ok := caches[ca]
 			if !ok {
@@ -78,13 +106,13 @@ func (m *Manager) Start(stop <-chan struct{}) error {
 
 	for ca, cos := range caches {
 		go func(ca Cache) {
-			if err := ca.Start(stop); err != nil {
-				errCh <- err
+			if err := ca.Start(cacheStop); err != nil {
+				report(err)
 			}
 		}(ca)
 		go func(ca Cache, cos ControllerSet) {
 			if ok := ca.WaitForCacheSync(stop); !ok {
-				errCh <- fmt.Errorf("failed to wait for caches to sync")
+				report(fmt.Errorf("failed to wait for caches to sync"))
 			}
 			for co := range cos {
 				wgs[co].Done()
@@ -92,19 +120,38 @@ func (m *Manager) Start(stop <-chan struct{}) error {
 		}(ca, cos)
 	}
 
+	var controllers sync.WaitGroup
 	for co := range m.controllers {
+		controllers.Add(1)
 		go func(co Controller) {
+			defer controllers.Done()
 			wgs[co].Wait()
+			select {
+			case <-stop:
+				return
+			default:
+			}
 			if err := co.Start(stop); err != nil {
-				errCh <- err
+				report(err)
 			}
 		}(co)
 	}
 
+	stopCaches := func() {
+		controllers.Wait()
+		close(cacheStop)
+	}
+
 	select {
 	case <-stop:
+		stopCaches()
 		return nil
 	case err := <-errCh:
+		// The controllers may still be running, until stop is closed and they've shut down.
+		go func() {
+			<-stop
+			stopCaches()
+		}()
 		return err
 	}
 }
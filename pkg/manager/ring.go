@@ -0,0 +1,86 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ring is a consistent hash ring of replicas. Each replica is placed on the ring at several points (virtual nodes),
+// so keys are spread evenly, and only the keys of a joining or leaving replica move.
+type ring struct {
+	members []string // sorted
+	points  []uint32 // sorted
+	owners  map[uint32]string
+}
+
+func newRing(members []string, virtualNodes int) *ring {
+	r := &ring{owners: make(map[uint32]string, len(members)*virtualNodes)}
+	r.members = append(r.members, members...)
+	sort.Strings(r.members)
+	for _, m := range r.members {
+		for i := 0; i < virtualNodes; i++ {
+			p := hash(m + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[p]; ok {
+				continue // collision, the first member (in sorted order) keeps the point
+			}
+			r.owners[p] = m
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the member owning key, i.e., the member of the first point clockwise from key's hash,
+// or an empty string if the ring is empty.
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// equal returns true if r and o have the same members.
+func (r *ring) equal(o *ring) bool {
+	if r == nil || o == nil {
+		return r == o
+	}
+	if len(r.members) != len(o.members) {
+		return false
+	}
+	for i := range r.members {
+		if r.members[i] != o.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hash hashes s with MD5, which spreads similar strings, e.g., the points of a replica, better than FNV.
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"testing"
+)
+
+// keys returns n cluster names to place on rings.
+func keys(n int) []string {
+	ks := make([]string, n)
+	for i := range ks {
+		ks[i] = fmt.Sprintf("cluster-%d", i)
+	}
+	return ks
+}
+
+func TestRingEmpty(t *testing.T) {
+	for _, r := range []*ring{newRing(nil, 100), newRing([]string{"a"}, 0)} {
+		if o := r.owner("cluster-0"); o != "" {
+			t.Errorf("empty ring with members %v: got owner %q, expected none", r.members, o)
+		}
+	}
+}
+
+func TestRingDeterministic(t *testing.T) {
+	r1 := newRing([]string{"a", "b", "c"}, 100)
+	r2 := newRing([]string{"c", "a", "b"}, 100)
+	if !r1.equal(r2) {
+		t.Error("rings with the same members in different orders aren't equal")
+	}
+	counts := make(map[string]int)
+	for _, k := range keys(1000) {
+		o1, o2 := r1.owner(k), r2.owner(k)
+		if o1 != o2 {
+			t.Errorf("%s: got owners %s and %s for the same members", k, o1, o2)
+		}
+		counts[o1]++
+	}
+	for _, m := range r1.members {
+		if counts[m] < 200 {
+			t.Errorf("member %s owns %d keys out of 1000, expected about a third", m, counts[m])
+		}
+	}
+}
+
+func TestRingMovesOnlyKeysOfChangedMember(t *testing.T) {
+	cases := []struct {
+		name          string
+		before, after []string
+		changed       string
+	}{
+		{"join", []string{"a", "b"}, []string{"a", "b", "c"}, "c"},
+		{"leave", []string{"a", "b", "c"}, []string{"a", "c"}, "b"},
+		{"first", []string{"a"}, []string{"a", "b"}, "b"},
+		{"last", []string{"a", "b"}, []string{"b"}, "a"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before, after := newRing(c.before, 100), newRing(c.after, 100)
+			if before.equal(after) {
+				t.Fatal("rings with different members are equal")
+			}
+			moved := 0
+			for _, k := range keys(1000) {
+				from, to := before.owner(k), after.owner(k)
+				if from == to {
+					continue
+				}
+				moved++
+				if from != c.changed && to != c.changed {
+					t.Errorf("%s moved from %s to %s, expected only moves from or to %s", k, from, to, c.changed)
+				}
+			}
+			if moved == 0 {
+				t.Errorf("no key moved")
+			}
+		})
+	}
+}
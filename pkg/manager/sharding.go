@@ -0,0 +1,384 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LabelShardGroup is the label of the Leases of sharded Managers, whose value is the ShardingOptions' Name.
+const LabelShardGroup = "multicluster.admiralty.io/shard-group"
+
+// ShardingOptions is used as an argument of NewSharded.
+type ShardingOptions struct {
+	// Client is used to manage the replicas' Leases in the hub cluster. Its scheme must recognize
+	// coordination.k8s.io/v1 Leases. Use a client that doesn't read from a cache, e.g., created with client.New,
+	// unless the cache is started independently of the Manager. Required.
+	Client client.Client
+	// Namespace is the namespace of the Leases. Required.
+	Namespace string
+	// Name identifies the group of replicas, e.g., the name of their Deployment.
+	// Leases are named after it and the replicas' identities, and labeled with it (see LabelShardGroup). Required.
+	Name string
+	// Identity identifies the replica within its group. It must be unique and a valid DNS subdomain.
+	// Defaults to the hostname, e.g., the name of the pod.
+	Identity string
+	// LeaseDuration is the time after which a replica that hasn't renewed its Lease is considered gone,
+	// and its clusters reassigned. Defaults to 15 seconds.
+	LeaseDuration time.Duration
+	// RenewPeriod is the period between renewals of the replica's Lease, and checks of the other replicas' Leases.
+	// Defaults to 5 seconds.
+	RenewPeriod time.Duration
+	// VirtualNodes is the number of points per replica on the hash ring. More points spread clusters more evenly.
+	// Defaults to 100.
+	VirtualNodes int
+	// Unsharded are the names of the clusters whose caches are started by all the replicas,
+	// e.g., a hub cluster read by Reconcilers when processing Requests of other clusters.
+	// Their Requests are still processed by a single replica.
+	Unsharded []string
+}
+
+// Shard tells Controllers which clusters they should process Requests for, and start caches of.
+// It is implemented by Manager. See SetShard in the controller package.
+type Shard interface {
+	// OwnsCluster returns true if Requests whose context is clusterName should be processed.
+	OwnsCluster(clusterName string) bool
+	// NeedsCache returns true if the cache of the cluster named clusterName should be started.
+	NeedsCache(clusterName string) bool
+}
+
+// shardable is implemented by Controllers that can be restricted to a Shard.
+type shardable interface {
+	SetShard(s Shard)
+}
+
+// reshardable is implemented by caches that can be stopped when their clusters leave the shard,
+// and restarted when they come back, e.g., cluster.Cluster. Other caches are started by all the replicas.
+type reshardable interface {
+	GetClusterName() string
+	Stop()
+	Restart(config *rest.Config) error
+}
+
+// NewSharded creates a Manager that only starts the caches of, and processes the Requests of, its shard of clusters.
+// Replicas of the Manager coordinate via Leases in a hub cluster: each replica renews a Lease, and clusters are
+// partitioned among the replicas whose Leases are live by consistent hashing of their names, so when a replica
+// joins or leaves, only the clusters it gains or loses move. On rebalancing, the caches of lost clusters are stopped,
+// and the caches of gained clusters are started or restarted (so the Controllers get events for all their objects).
+// A replica that can't renew its Lease for LeaseDuration gives up its shard until it can.
+//
+// Sharding suits Reconcilers that, when processing a Request, only read the cluster of the Request's context,
+// and clusters listed in ShardingOptions' Unsharded. Clusters sharing a cache (see cluster.Cluster's CloneWithName)
+// should have the same shard, e.g., all be Unsharded.
+func NewSharded(o ShardingOptions) (*Manager, error) {
+	if o.Client == nil {
+		return nil, fmt.Errorf("sharding client is required")
+	}
+	if o.Namespace == "" || o.Name == "" {
+		return nil, fmt.Errorf("sharding namespace and name are required")
+	}
+	if o.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get hostname for sharding identity: %v", err)
+		}
+		o.Identity = strings.ToLower(hostname)
+	}
+	if o.LeaseDuration == 0 {
+		o.LeaseDuration = 15 * time.Second
+	}
+	if o.RenewPeriod == 0 {
+		o.RenewPeriod = 5 * time.Second
+	}
+	if o.VirtualNodes <= 0 {
+		o.VirtualNodes = 100
+	}
+
+	m := New()
+	m.sharding = &o
+	m.unsharded = make(map[string]bool, len(o.Unsharded))
+	for _, name := range o.Unsharded {
+		m.unsharded[name] = true
+	}
+	m.shardStopped = make(CacheSet)
+	return m, nil
+}
+
+// OwnsCluster returns true if the Manager's Controllers should process Requests whose context is clusterName,
+// i.e., if the Manager isn't sharded or the cluster is in its shard.
+func (m *Manager) OwnsCluster(clusterName string) bool {
+	if m.sharding == nil {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ownsCluster(m.ring, clusterName)
+}
+
+// NeedsCache returns true if the cache of the cluster named clusterName should be started,
+// i.e., if the Manager isn't sharded, the cluster is in its shard, or the cluster is Unsharded.
+func (m *Manager) NeedsCache(clusterName string) bool {
+	if m.sharding == nil {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.needsCache(m.ring, clusterName)
+}
+
+func (m *Manager) ownsCluster(r *ring, clusterName string) bool {
+	return r != nil && r.owner(clusterName) == m.sharding.Identity
+}
+
+func (m *Manager) needsCache(r *ring, clusterName string) bool {
+	return m.unsharded[clusterName] || m.ownsCluster(r, clusterName)
+}
+
+// needsCacheOf is like NeedsCache for a cache; caches that can't be resharded are always needed.
+func (m *Manager) needsCacheOf(ca Cache) bool {
+	rs, ok := ca.(reshardable)
+	if !ok {
+		return true
+	}
+	return m.NeedsCache(rs.GetClusterName())
+}
+
+// startSharded joins the replicas, then starts the caches of the Manager's shard and the Controllers,
+// and rebalances the shard as replicas join and leave, until stop is closed. The caches are started until cacheStop
+// is closed (see start). The replica's Lease is deleted on return,
+// so its clusters are reassigned without waiting for the Lease to expire.
+func (m *Manager) startSharded(stop <-chan struct{}, cacheStop chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	if err := m.renewLease(ctx); err != nil {
+		return fmt.Errorf("cannot create shard lease: %v", err)
+	}
+	r, err := m.liveRing(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("cannot list shard leases: %v", err)
+	}
+	m.mu.Lock()
+	m.ring = r
+	m.mu.Unlock()
+
+	for co := range m.controllers {
+		if s, ok := co.(shardable); ok {
+			s.SetShard(m)
+		}
+	}
+
+	go m.rebalancePeriodically(ctx, stop, cacheStop)
+	defer m.deleteLease()
+
+	return m.start(stop, cacheStop)
+}
+
+// rebalancePeriodically renews the replica's Lease, and rebalances the shard when the live replicas change,
+// until stop is closed. Caches joining the shard are started until cacheStop is closed.
+func (m *Manager) rebalancePeriodically(ctx context.Context, stop <-chan struct{}, cacheStop <-chan struct{}) {
+	lastRenewal := time.Now()
+	t := time.NewTicker(m.sharding.RenewPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		if err := m.renewLease(ctx); err != nil {
+			utilruntime.HandleError(fmt.Errorf("cannot renew shard lease: %v", err))
+		} else {
+			lastRenewal = time.Now()
+		}
+
+		r, err := m.liveRing(ctx, lastRenewal)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("cannot list shard leases: %v", err))
+			if time.Since(lastRenewal) < m.sharding.LeaseDuration {
+				continue
+			}
+			r = newRing(nil, 0) // our Lease has expired, give up the shard
+		}
+		m.rebalance(cacheStop, r)
+	}
+}
+
+// rebalance switches to ring r. The caches of the clusters that leave the shard are stopped,
+// and the caches of the clusters that join it are started until cacheStop is closed,
+// or restarted to replay their objects' events. The caches are only compared under the lock; they're stopped,
+// started, and restarted afterwards, so Controllers checking the shard (see OwnsCluster) aren't blocked meanwhile.
+func (m *Manager) rebalance(cacheStop <-chan struct{}, r *ring) {
+	caches := make(CacheSet)
+	for co := range m.controllers {
+		for ca := range co.GetCaches() {
+			caches[ca] = struct{}{}
+		}
+	}
+
+	m.mu.Lock()
+	old := m.ring
+	if old.equal(r) {
+		m.mu.Unlock()
+		return
+	}
+	m.ring = r
+
+	stops, starts, restarts := m.classify(old, r, caches)
+	m.mu.Unlock()
+
+	for _, rs := range stops {
+		rs.Stop()
+	}
+	for _, rs := range restarts {
+		if err := rs.Restart(nil); err != nil {
+			utilruntime.HandleError(fmt.Errorf("cannot restart cache of cluster %s joining shard: %v", rs.GetClusterName(), err))
+			// A Cluster that fails to restart remains stopped; try again next time it joins the shard.
+			m.mu.Lock()
+			m.shardStopped[rs.(Cache)] = struct{}{}
+			m.mu.Unlock()
+		}
+	}
+	for _, rs := range starts {
+		go func(ca Cache, name string) {
+			if err := ca.Start(cacheStop); err != nil {
+				utilruntime.HandleError(fmt.Errorf("cannot start cache of cluster %s joining shard: %v", name, err))
+			}
+		}(rs.(Cache), rs.GetClusterName())
+	}
+}
+
+// classify returns the caches to stop, start, and restart when the ring changes from old to r,
+// and records the caches to stop as stopped by resharding. It must be called with the lock held.
+func (m *Manager) classify(old, r *ring, caches CacheSet) (stops, starts, restarts []reshardable) {
+	for ca := range caches {
+		rs, ok := ca.(reshardable)
+		if !ok {
+			continue
+		}
+		name := rs.GetClusterName()
+		wasNeeded := m.needsCache(old, name)
+		isNeeded := m.needsCache(r, name)
+		_, stopped := m.shardStopped[ca]
+
+		switch {
+		case wasNeeded && !isNeeded:
+			stops = append(stops, rs)
+			m.shardStopped[ca] = struct{}{}
+		case isNeeded && stopped:
+			restarts = append(restarts, rs)
+			delete(m.shardStopped, ca)
+		case isNeeded && !wasNeeded:
+			starts = append(starts, rs)
+		case isNeeded && !m.ownsCluster(old, name) && m.ownsCluster(r, name):
+			// The cache was already started, e.g., the cluster is Unsharded, but events were ignored.
+			restarts = append(restarts, rs)
+		}
+	}
+	return stops, starts, restarts
+}
+
+func (m *Manager) leaseName() string {
+	return m.sharding.Name + "-" + m.sharding.Identity
+}
+
+// renewLease creates or renews the replica's Lease.
+func (m *Manager) renewLease(ctx context.Context) error {
+	o := m.sharding
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(o.LeaseDuration / time.Second)
+
+	l := &coordinationv1.Lease{}
+	err := o.Client.Get(ctx, client.ObjectKey{Namespace: o.Namespace, Name: m.leaseName()}, l)
+	if errors.IsNotFound(err) {
+		l = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: o.Namespace,
+				Name:      m.leaseName(),
+				Labels:    map[string]string{LabelShardGroup: o.Name},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &o.Identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return o.Client.Create(ctx, l)
+	} else if err != nil {
+		return err
+	}
+
+	l.Spec.HolderIdentity = &o.Identity
+	l.Spec.LeaseDurationSeconds = &duration
+	l.Spec.RenewTime = &now
+	return o.Client.Update(ctx, l)
+}
+
+// deleteLease deletes the replica's Lease, if possible within a RenewPeriod.
+func (m *Manager) deleteLease() {
+	o := m.sharding
+	ctx, cancel := context.WithTimeout(context.Background(), o.RenewPeriod)
+	defer cancel()
+	l := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: o.Namespace, Name: m.leaseName()}}
+	if err := o.Client.Delete(ctx, l); err != nil && !errors.IsNotFound(err) {
+		utilruntime.HandleError(fmt.Errorf("cannot delete shard lease: %v", err))
+	}
+}
+
+// liveRing lists the Leases of the group and returns the ring of the replicas whose Leases are live.
+// The replica itself is included if it last renewed its Lease (at lastRenewal) within LeaseDuration.
+func (m *Manager) liveRing(ctx context.Context, lastRenewal time.Time) (*ring, error) {
+	o := m.sharding
+	ll := &coordinationv1.LeaseList{}
+	if err := o.Client.List(ctx, ll, client.InNamespace(o.Namespace), client.MatchingLabels{LabelShardGroup: o.Name}); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var members []string
+	for _, l := range ll.Items {
+		s := l.Spec
+		if s.HolderIdentity == nil || *s.HolderIdentity == o.Identity || s.RenewTime == nil || s.LeaseDurationSeconds == nil {
+			continue
+		}
+		if s.RenewTime.Add(time.Duration(*s.LeaseDurationSeconds) * time.Second).Before(now) {
+			continue
+		}
+		members = append(members, *s.HolderIdentity)
+	}
+	if now.Sub(lastRenewal) < o.LeaseDuration {
+		members = append(members, o.Identity)
+	}
+	return newRing(members, o.VirtualNodes), nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+// fakeCluster is a reshardable cache.
+type fakeCluster struct {
+	name string
+}
+
+func (c *fakeCluster) Start(stop <-chan struct{}) error           { return nil }
+func (c *fakeCluster) WaitForCacheSync(stop <-chan struct{}) bool { return true }
+func (c *fakeCluster) GetClusterName() string                     { return c.name }
+func (c *fakeCluster) Stop()                                      {}
+func (c *fakeCluster) Restart(config *rest.Config) error          { return nil }
+
+// fakeCache is a cache that can't be resharded.
+type fakeCache struct{}
+
+func (c *fakeCache) Start(stop <-chan struct{}) error           { return nil }
+func (c *fakeCache) WaitForCacheSync(stop <-chan struct{}) bool { return true }
+
+// clustersOwnedBy returns the names of the clusters owned by owners[i] in rings[i], for all i.
+func clustersOwnedBy(rings []*ring, owners ...string) []string {
+	var ns []string
+	for _, k := range keys(1000) {
+		match := true
+		for i, r := range rings {
+			if r.owner(k) != owners[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			ns = append(ns, k)
+		}
+	}
+	return ns
+}
+
+func names(rss []reshardable) []string {
+	var ns []string
+	for _, rs := range rss {
+		ns = append(ns, rs.GetClusterName())
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// TestClassify rebalances replica a's caches as replica b leaves and rejoins, and as a's Lease expires and is renewed.
+func TestClassify(t *testing.T) {
+	a := newRing([]string{"a"}, 100)
+	ab := newRing([]string{"a", "b"}, 100)
+	none := newRing(nil, 0)
+
+	ownedByA := clustersOwnedBy([]*ring{ab}, "a")
+	ownedByB := clustersOwnedBy([]*ring{ab}, "b")
+	if len(ownedByA) < 2 || len(ownedByB) < 2 {
+		t.Fatal("not enough clusters in each shard")
+	}
+	own := &fakeCluster{name: ownedByA[0]}
+	gained := &fakeCluster{name: ownedByB[0]}
+	unshardedOwn := &fakeCluster{name: ownedByA[1]}
+	unshardedOther := &fakeCluster{name: ownedByB[1]}
+
+	m := &Manager{
+		sharding:     &ShardingOptions{Identity: "a"},
+		unsharded:    map[string]bool{unshardedOwn.name: true, unshardedOther.name: true},
+		shardStopped: make(CacheSet),
+	}
+	caches := CacheSet{own: {}, gained: {}, unshardedOwn: {}, unshardedOther: {}, &fakeCache{}: {}}
+
+	steps := []struct {
+		name                    string
+		before, after           *ring
+		stops, starts, restarts []*fakeCluster
+		stopped                 []*fakeCluster
+	}{
+		{
+			name: "b leaves", before: ab, after: a,
+			starts:   []*fakeCluster{gained},
+			restarts: []*fakeCluster{unshardedOther}, // already started, but its events were ignored
+		},
+		{
+			name: "b joins", before: a, after: ab,
+			stops:   []*fakeCluster{gained},
+			stopped: []*fakeCluster{gained},
+		},
+		{
+			name: "lease expires", before: ab, after: none,
+			stops:   []*fakeCluster{own},
+			stopped: []*fakeCluster{gained, own},
+		},
+		{
+			name: "lease renewed alone", before: none, after: a,
+			restarts: []*fakeCluster{own, gained, unshardedOwn, unshardedOther},
+		},
+	}
+	for _, s := range steps {
+		stops, starts, restarts := m.classify(s.before, s.after, caches)
+		for _, c := range []struct {
+			what     string
+			got      []reshardable
+			expected []*fakeCluster
+		}{
+			{"stops", stops, s.stops},
+			{"starts", starts, s.starts},
+			{"restarts", restarts, s.restarts},
+		} {
+			if got, expected := names(c.got), fakeNames(c.expected); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: got %s %v, expected %v", s.name, c.what, got, expected)
+			}
+		}
+		var stopped []reshardable
+		for ca := range m.shardStopped {
+			stopped = append(stopped, ca.(reshardable))
+		}
+		if got, expected := names(stopped), fakeNames(s.stopped); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got stopped %v, expected %v", s.name, got, expected)
+		}
+	}
+}
+
+func fakeNames(cs []*fakeCluster) []string {
+	rss := make([]reshardable, len(cs))
+	for i, c := range cs {
+		rss[i] = c
+	}
+	return names(rss)
+}
@@ -25,6 +25,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"admiralty.io/multicluster-controller/pkg/audit"
 	"admiralty.io/multicluster-controller/pkg/cluster"
 	"admiralty.io/multicluster-controller/pkg/controller"
 	"admiralty.io/multicluster-controller/pkg/patterns"
@@ -42,7 +43,7 @@ func NewController(ctx context.Context, c *cluster.Cluster, prototype runtime.Ob
 		return nil, fmt.Errorf("getting GVKs for prototype: %v", err)
 	}
 	if len(gvks) != 1 {
-		return nil, fmt.Errorf("scheme has %d GVK(s) for prototype when 1 is expected")
+		return nil, fmt.Errorf("scheme has %d GVK(s) for prototype when 1 is expected", len(gvks))
 	}
 	gvk := gvks[0]
 
@@ -70,8 +71,9 @@ type reconciler struct {
 }
 
 func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := audit.NewContext(context.Background(), "decorator", req)
 	obj := r.prototype.DeepCopyObject()
-	if err := r.client.Get(context.Background(), req.NamespacedName, obj); err != nil {
+	if err := r.client.Get(ctx, req.NamespacedName, obj); err != nil {
 		if !errors.IsNotFound(err) {
 			return reconcile.Result{}, fmt.Errorf("cannot get %s: %v",
 				r.objectErrorString(req.Name, req.Namespace), err)
@@ -94,7 +96,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 			r.objectErrorString(req.Name, req.Namespace), err)
 	}
 
-	if err := r.client.Update(context.Background(), obj); err != nil && !patterns.IsOptimisticLockError(err) {
+	if err := r.client.Update(ctx, obj); err != nil && !patterns.IsOptimisticLockError(err) {
 		return reconcile.Result{}, fmt.Errorf("cannot update %s: %v",
 			r.objectErrorString(req.Name, req.Namespace), err)
 	}
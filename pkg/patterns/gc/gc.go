@@ -19,6 +19,7 @@ package gc
 import (
 	"context"
 	"fmt"
+	"log"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -29,6 +30,7 @@ import (
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"admiralty.io/multicluster-controller/pkg/audit"
 	"admiralty.io/multicluster-controller/pkg/cluster"
 	"admiralty.io/multicluster-controller/pkg/controller"
 	"admiralty.io/multicluster-controller/pkg/patterns"
@@ -48,7 +50,7 @@ func NewController(ctx context.Context, parentClusters []*cluster.Cluster, child
 		return nil, fmt.Errorf("getting GVKs for parent prototype: %v", err)
 	}
 	if len(parentGVKs) != 1 {
-		return nil, fmt.Errorf("parent cluster scheme has %d GVK(s) for parent prototype when 1 is expected")
+		return nil, fmt.Errorf("parent cluster scheme has %d GVK(s) for parent prototype when 1 is expected", len(parentGVKs))
 	}
 	r.parentGVK = parentGVKs[0]
 
@@ -57,19 +59,22 @@ func NewController(ctx context.Context, parentClusters []*cluster.Cluster, child
 		return nil, fmt.Errorf("getting GVKs for child prototype: %v", err)
 	}
 	if len(childGVKs) != 1 {
-		return nil, fmt.Errorf("child cluster scheme has %d GVK(s) for child prototype when 1 is expected")
+		return nil, fmt.Errorf("child cluster scheme has %d GVK(s) for child prototype when 1 is expected", len(childGVKs))
 	}
 	r.childGVK = childGVKs[0]
 
 	co := controller.New(r, controller.Options{})
+	r.logger = co.Logger
 
 	r.parentClients = make(map[string]client.Client, len(parentClusters))
+	r.parentClusters = make(map[string]*cluster.Cluster, len(parentClusters))
 	for _, clu := range parentClusters {
 		cli, err := clu.GetDelegatingClient()
 		if err != nil {
 			return nil, fmt.Errorf("getting delegating client for parent cluster: %v", err)
 		}
 		r.parentClients[clu.Name] = cli
+		r.parentClusters[clu.Name] = clu
 
 		if err := co.WatchResourceReconcileObject(ctx, clu, r.ParentPrototype, r.ParentWatchOptions); err != nil {
 			return nil, fmt.Errorf("setting up watch for %s: %v", r.parentResourceErrorString(clu.Name), err)
@@ -94,11 +99,9 @@ func NewController(ctx context.Context, parentClusters []*cluster.Cluster, child
 		r.childWriters[p.Name] = make(map[string]client.Client, len(childClusters))
 		for _, c := range childClusters {
 			if o.GetImpersonatorForChildWriter != nil {
-				cfg := rest.CopyConfig(c.Config)
-				cfg.Impersonate = rest.ImpersonationConfig{
+				cli, err := c.GetImpersonatingClient(rest.ImpersonationConfig{
 					UserName: r.GetImpersonatorForChildWriter(p.Name),
-				}
-				cli, err := client.New(cfg, client.Options{})
+				})
 				if err != nil {
 					return nil, err
 				}
@@ -129,11 +132,13 @@ type Options struct {
 }
 
 type reconciler struct {
-	parentClients map[string]client.Client
-	childClients  map[string]client.Client
-	childWriters  map[string]map[string]client.Client
-	parentGVK     schema.GroupVersionKind
-	childGVK      schema.GroupVersionKind
+	parentClients  map[string]client.Client
+	parentClusters map[string]*cluster.Cluster
+	childClients   map[string]client.Client
+	childWriters   map[string]map[string]client.Client
+	parentGVK      schema.GroupVersionKind
+	childGVK       schema.GroupVersionKind
+	logger         *log.Logger
 	Options
 }
 
@@ -152,6 +157,8 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 		return reconcile.Result{}, nil
 	}
 
+	ctx := audit.NewContext(context.Background(), "gc", req)
+
 	parent := r.ParentPrototype.DeepCopyObject()
 	child := r.ChildPrototype.DeepCopyObject()
 	expectedChild := r.ChildPrototype.DeepCopyObject()
@@ -160,7 +167,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 	childMeta := child.(metav1.Object)
 	expectedChildMeta := child.(metav1.Object)
 
-	if err := r.parentClients[parentClusterName].Get(context.Background(), req.NamespacedName, parent); err != nil {
+	if err := r.parentClients[parentClusterName].Get(ctx, req.NamespacedName, parent); err != nil {
 		if !errors.IsNotFound(err) {
 			return reconcile.Result{}, fmt.Errorf("cannot get %s: %v",
 				r.parentObjectErrorString(req.Name, req.Namespace, parentClusterName), err)
@@ -192,7 +199,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 			r.parentObjectErrorString(req.Name, req.Namespace, parentClusterName), err)
 	}
 	if needUpdate {
-		if err := r.parentClients[parentClusterName].Update(context.Background(), parent); err != nil {
+		if err := r.parentClients[parentClusterName].Update(ctx, parent); err != nil {
 			if patterns.IsOptimisticLockError(err) {
 				return reconcile.Result{}, nil
 			} else {
@@ -202,7 +209,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 		}
 	}
 	if needStatusUpdate {
-		if err := r.parentClients[parentClusterName].Status().Update(context.Background(), parent); err != nil {
+		if err := r.parentClients[parentClusterName].Status().Update(ctx, parent); err != nil {
 			if patterns.IsOptimisticLockError(err) {
 				return reconcile.Result{}, nil
 			} else {
@@ -226,14 +233,14 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 
 	if parentTerminating {
 		if childFound {
-			if err := r.childWriters[parentClusterName][childClusterName].Delete(context.Background(), child); err != nil && !errors.IsNotFound(err) {
+			if err := r.childWriters[parentClusterName][childClusterName].Delete(ctx, child); err != nil && !errors.IsNotFound(err) {
 				return reconcile.Result{}, fmt.Errorf("cannot delete %s: %v",
 					r.childObjectErrorString(childMeta.GetName(), childMeta.GetNamespace(), childClusterName), err)
 			}
 		} else if parentHasFinalizer {
 			// remove finalizer
 			parentMeta.SetFinalizers(append(finalizers[:j], finalizers[j+1:]...))
-			if err := r.parentClients[parentClusterName].Update(context.Background(), parent); err != nil && !patterns.IsOptimisticLockError(err) {
+			if err := r.parentClients[parentClusterName].Update(ctx, parent); err != nil && !patterns.IsOptimisticLockError(err) {
 				return reconcile.Result{}, fmt.Errorf("cannot remove finalizer from %s: %v",
 					r.parentObjectErrorString(parentMeta.GetName(), parentMeta.GetNamespace(), parentClusterName), err)
 			}
@@ -241,7 +248,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 	} else {
 		if !parentHasFinalizer {
 			parentMeta.SetFinalizers(append(finalizers, "multicluster.admiralty.io/multiclusterForegroundDeletion"))
-			if err := r.parentClients[parentClusterName].Update(context.Background(), parent); err != nil && !patterns.IsOptimisticLockError(err) {
+			if err := r.parentClients[parentClusterName].Update(ctx, parent); err != nil && !patterns.IsOptimisticLockError(err) {
 				return reconcile.Result{}, fmt.Errorf("cannot add finalizer to %s: %v",
 					r.parentObjectErrorString(parentMeta.GetName(), parentMeta.GetNamespace(), parentClusterName), err)
 			}
@@ -253,7 +260,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 				}
 			}
 			if !childFound {
-				if err := r.childWriters[parentClusterName][childClusterName].Create(context.Background(), expectedChild); err != nil && !errors.IsAlreadyExists(err) {
+				if err := r.childWriters[parentClusterName][childClusterName].Create(ctx, expectedChild); err != nil && !errors.IsAlreadyExists(err) {
 					return reconcile.Result{}, fmt.Errorf("cannot create %s: %v",
 						r.childObjectErrorString(expectedChildMeta.GetName(), expectedChildMeta.GetNamespace(), childClusterName), err)
 				}
@@ -264,7 +271,7 @@ func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error)
 						r.childObjectErrorString(childMeta.GetName(), childMeta.GetNamespace(), childClusterName), err)
 				}
 				if needUpdate {
-					if err := r.childWriters[parentClusterName][childClusterName].Update(context.Background(), child); err != nil && !patterns.IsOptimisticLockError(err) {
+					if err := r.childWriters[parentClusterName][childClusterName].Update(ctx, child); err != nil && !patterns.IsOptimisticLockError(err) {
 						return reconcile.Result{}, fmt.Errorf("cannot update %s: %v",
 							r.childObjectErrorString(childMeta.GetName(), childMeta.GetNamespace(), childClusterName), err)
 					}
@@ -331,6 +338,15 @@ func (r *reconciler) makeChildWrapper(parent runtime.Object, expectedChild runti
 	expectedChildMeta.SetLabels(l)
 
 	ref := reference.NewMulticlusterOwnerReference(parentMeta, r.parentGVK, parentMeta.GetClusterName())
+	// The cluster UID is optional in controller references, e.g., if RBAC forbids reading kube-system.
+	// It's discovered lazily, so parent clusters unreachable when the controller is created are identified later.
+	if clu, ok := r.parentClusters[parentMeta.GetClusterName()]; ok {
+		uid, err := clu.GetClusterUID()
+		if err != nil {
+			r.logger.Printf("cannot get UID of parent cluster %s, controller reference won't include it: %v", clu.Name, err)
+		}
+		ref.ClusterUID = uid
+	}
 	if err := reference.SetMulticlusterControllerReference(expectedChildMeta, ref); err != nil {
 		return fmt.Errorf("cannot set multi-cluster controller reference: %v", err)
 	}
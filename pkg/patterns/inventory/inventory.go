@@ -0,0 +1,232 @@
+/*
+Copyright 2020 The Multicluster-Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory builds member Clusters from the Cluster custom resources of a management cluster
+// (see config/crd/clusters.yaml), and keeps the resources' statuses fresh.
+package inventory // import "admiralty.io/multicluster-controller/pkg/patterns/inventory"
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"admiralty.io/multicluster-controller/pkg/apis/multicluster/v1alpha1"
+	"admiralty.io/multicluster-controller/pkg/audit"
+	"admiralty.io/multicluster-controller/pkg/cluster"
+	"admiralty.io/multicluster-controller/pkg/controller"
+	"admiralty.io/multicluster-controller/pkg/patterns"
+	"admiralty.io/multicluster-controller/pkg/reconcile"
+)
+
+// Options is used as an argument of NewController.
+type Options struct {
+	// Set receives a member Cluster per Cluster resource, named after the resource, e.g., to be watched by
+	// other controllers (see controller.Controller's WatchClusterSet). Members are replaced when their resources'
+	// specs change, and removed (and stopped) when their resources are deleted. Required.
+	Set *cluster.ClusterSet
+	// ClusterOptions are the options of the member Clusters. Their Labels and ConfigProvider are set
+	// from the Cluster resources.
+	ClusterOptions cluster.Options
+	// ProbePeriod is the period between updates of the Cluster resources' statuses. Defaults to 1 minute.
+	ProbePeriod time.Duration
+}
+
+// NewController creates a controller that watches the Cluster resources of the management cluster mgmt,
+// builds and updates the member Clusters in o.Set accordingly, and periodically probes the members
+// to update the resources' statuses. The scheme of mgmt must recognize the v1alpha1 types (see v1alpha1.AddToScheme).
+// The kubeconfig Secrets are read directly from mgmt's API server, rather than from a cache,
+// so the controller doesn't need to list and watch all the Secrets of mgmt.
+// The member Clusters read their configs lazily: if a Secret is missing or invalid, the watches of the member
+// fail, and the controllers watching o.Set retry them (see controller.Controller's WatchClusterSet).
+func NewController(ctx context.Context, mgmt *cluster.Cluster, o Options) (*controller.Controller, error) {
+	if o.Set == nil {
+		return nil, fmt.Errorf("cluster set is required")
+	}
+	if o.ProbePeriod == 0 {
+		o.ProbePeriod = time.Minute
+	}
+
+	gvk := v1alpha1.SchemeGroupVersion.WithKind("Cluster")
+	if !mgmt.GetScheme().Recognizes(gvk) {
+		return nil, fmt.Errorf("scheme of cluster %s doesn't recognize %s", mgmt.Name, gvk)
+	}
+
+	c, err := mgmt.GetDelegatingClient()
+	if err != nil {
+		return nil, fmt.Errorf("getting delegating client: %v", err)
+	}
+
+	cfg := mgmt.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("cannot get config of cluster %s", mgmt.Name)
+	}
+	secretClient, err := client.New(cfg, client.Options{Scheme: mgmt.GetScheme()})
+	if err != nil {
+		return nil, fmt.Errorf("creating secret client: %v", err)
+	}
+
+	r := &reconciler{
+		client:       c,
+		secretClient: secretClient,
+		specs:        make(map[string]v1alpha1.ClusterSpec),
+		Options:      o,
+	}
+
+	co := controller.New(r, controller.Options{})
+
+	if err := co.WatchResourceReconcileObject(ctx, mgmt, &v1alpha1.Cluster{}, controller.WatchOptions{}); err != nil {
+		return nil, fmt.Errorf("setting up cluster resource watch: %v", err)
+	}
+
+	return co, nil
+}
+
+type reconciler struct {
+	client       client.Client
+	secretClient client.Client // uncached, not to cache all the Secrets of the management cluster
+
+	mu    sync.Mutex
+	specs map[string]v1alpha1.ClusterSpec // specs the members were built from, by name
+
+	Options
+}
+
+func (r *reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := audit.NewContext(context.Background(), "inventory", req)
+	obj := &v1alpha1.Cluster{}
+	if err := r.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		if !errors.IsNotFound(err) {
+			return reconcile.Result{}, fmt.Errorf("cannot get cluster resource %s: %v", req.Name, err)
+		}
+		r.forget(req.Name)
+		return reconcile.Result{}, nil
+	}
+
+	m := r.member(obj)
+
+	status := r.probe(ctx, m)
+	status.ObservedGeneration = obj.Generation
+	// Status updates trigger watch events, hence new reconciles and probes. Only update the status if the probe
+	// changed it, so probes are paced by ProbePeriod.
+	if !statusChanged(obj.Status, status) {
+		return reconcile.Result{RequeueAfter: r.ProbePeriod}, nil
+	}
+	obj.Status = status
+	if err := r.client.Status().Update(ctx, obj); err != nil && !patterns.IsOptimisticLockError(err) {
+		return reconcile.Result{}, fmt.Errorf("cannot update status of cluster resource %s: %v", req.Name, err)
+	}
+
+	return reconcile.Result{RequeueAfter: r.ProbePeriod}, nil
+}
+
+// statusChanged returns true if the probed status differs from the current one other than by its probe time.
+func statusChanged(current, probed v1alpha1.ClusterStatus) bool {
+	current.LastProbeTime = nil
+	probed.LastProbeTime = nil
+	return !reflect.DeepEqual(current, probed)
+}
+
+// member returns the member Cluster of obj, built or rebuilt if needed.
+func (r *reconciler) member(obj *v1alpha1.Cluster) *cluster.Cluster {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.Set.Get(obj.Name)
+	if spec, ok := r.specs[obj.Name]; ok && old != nil && reflect.DeepEqual(spec, obj.Spec) {
+		return old
+	}
+
+	ref := obj.Spec.KubeconfigSecret
+	o := r.ClusterOptions
+	o.Labels = obj.Spec.Labels
+	o.ConfigProvider = cluster.KubeconfigSecret(r.secretClient, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name},
+		ref.Key, ref.Context)
+	m := cluster.New(obj.Name, nil, o)
+
+	if old != nil {
+		old.Stop()
+	}
+	r.Set.Add(m)
+	r.specs[obj.Name] = *obj.Spec.DeepCopy()
+	return m
+}
+
+// forget removes and stops the member Cluster named name, if any.
+func (r *reconciler) forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m := r.Set.Get(name); m != nil {
+		m.Stop()
+		r.Set.Remove(name)
+	}
+	delete(r.specs, name)
+}
+
+// probe probes m's API server, and discovers its version and API group versions if it's reachable.
+func (r *reconciler) probe(ctx context.Context, m *cluster.Cluster) v1alpha1.ClusterStatus {
+	h := m.ProbeHealth(ctx)
+	now := metav1.NewTime(h.LastProbeTime)
+	s := v1alpha1.ClusterStatus{
+		Connectivity:  v1alpha1.Connectivity(h.State),
+		LastProbeTime: &now,
+	}
+	if h.LastError != nil {
+		s.Message = h.LastError.Error()
+		return s
+	}
+
+	cfg := m.GetConfig()
+	if cfg == nil {
+		s.Message = fmt.Sprintf("cannot get config of cluster %s", m.Name)
+		return s
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		s.Message = err.Error()
+		return s
+	}
+
+	v, err := dc.ServerVersion()
+	if err != nil {
+		s.Message = fmt.Sprintf("cannot discover version: %v", err)
+		return s
+	}
+	s.KubernetesVersion = v.GitVersion
+
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		s.Message = fmt.Sprintf("cannot discover API groups: %v", err)
+		return s
+	}
+	for _, g := range groups.Groups {
+		for _, gv := range g.Versions {
+			s.APIGroupVersions = append(s.APIGroupVersions, gv.GroupVersion)
+		}
+	}
+	sort.Strings(s.APIGroupVersions)
+
+	return s
+}
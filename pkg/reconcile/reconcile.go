@@ -17,15 +17,19 @@ limitations under the License.
 package reconcile // import "admiralty.io/multicluster-controller/pkg/reconcile"
 
 import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // Request contains the information needed by a multicluster Reconciler to Reconcile:
 // a context, namespace, and name.
+// GroupVersionKind is optional. It is set by the handlers when the kind of the object to reconcile is known,
+// so a Reconciler watching several kinds can tell them apart.
 type Request struct {
 	Context string
 	types.NamespacedName
+	GroupVersionKind schema.GroupVersionKind
 }
 
 // Result is the return type of a Reconciler's Reconcile method.
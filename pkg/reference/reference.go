@@ -17,7 +17,10 @@ limitations under the License.
 package reference // import "admiralty.io/multicluster-controller/pkg/reference"
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -26,6 +29,13 @@ import (
 
 var key string = "multicluster.admiralty.io/controller-reference"
 
+// Version is the current version of the multicluster controller reference annotation's encoding.
+// Annotations without a version were written before versioning was introduced (v0).
+// v2 added ClusterUID.
+const Version = "v2"
+
+const versionV1 = "v1"
+
 type MulticlusterOwnerReference struct {
 	APIVersion string    `json:"apiVersion" protobuf:"bytes,5,opt,name=apiVersion"`
 	Kind       string    `json:"kind" protobuf:"bytes,1,opt,name=kind"`
@@ -38,6 +48,10 @@ type MulticlusterOwnerReference struct {
 
 	ClusterName string `json:"clusterName" protobuf:"bytes,9,opt,name=clusterName"`
 	Namespace   string `json:"namespace" protobuf:"bytes,8,opt,name=namespace"`
+	// ClusterUID is the stable identity of the owner's cluster (see cluster.GetClusterUID),
+	// whereas ClusterName is only the name under which the cluster is known by the controller that set the reference.
+	// +optional
+	ClusterUID types.UID `json:"clusterUID,omitempty" protobuf:"bytes,10,opt,name=clusterUID,casttype=k8s.io/apimachinery/pkg/types.UID"`
 }
 
 func NewMulticlusterOwnerReference(owner metav1.Object, gvk schema.GroupVersionKind, clusterName string) *MulticlusterOwnerReference {
@@ -56,28 +70,35 @@ func NewMulticlusterOwnerReference(owner metav1.Object, gvk schema.GroupVersionK
 	}
 }
 
-func GetMulticlusterControllerOf(o metav1.Object) *MulticlusterOwnerReference {
+// GetMulticlusterControllerOf returns the multicluster controller reference of o, or nil if o doesn't have one.
+// An error is returned if the annotation is malformed, invalid, or of an unsupported version.
+func GetMulticlusterControllerOf(o metav1.Object) (*MulticlusterOwnerReference, error) {
 	// HACK: until we come up with a better solution for multicluster owner (and controller) references
 	// suggestions: a MulticlusterOwnerReference CRD, or upstream support (along with ClusterName)
 	a := o.GetAnnotations()
 	s, ok := a[key]
 	if !ok {
-		return nil
+		return nil, nil
 	}
 
-	r := &MulticlusterOwnerReference{}
-	if err := json.Unmarshal([]byte(s), r); err != nil {
-		return nil
+	r, _, err := decode(s)
+	if err != nil {
+		return nil, err
 	}
-	return r
+	return r, nil
 }
 
+// SetMulticlusterControllerReference sets the multicluster controller reference annotation of o,
+// encoded in the current version.
 func SetMulticlusterControllerReference(o metav1.Object, ref *MulticlusterOwnerReference) error {
+	if err := validate(ref); err != nil {
+		return err
+	}
 	a := o.GetAnnotations()
 	if a == nil {
 		a = make(map[string]string)
 	}
-	b, err := json.Marshal(&ref)
+	b, err := json.Marshal(&encodedReference{Version: Version, MulticlusterOwnerReference: *ref})
 	if err != nil {
 		return err
 	}
@@ -85,3 +106,112 @@ func SetMulticlusterControllerReference(o metav1.Object, ref *MulticlusterOwnerR
 	o.SetAnnotations(a)
 	return nil
 }
+
+// MigrateMulticlusterControllerReference re-encodes the multicluster controller reference annotation of o
+// in the current version, if it was encoded in a previous version. It returns true if the annotation was changed,
+// in which case the caller is responsible for updating o.
+func MigrateMulticlusterControllerReference(o metav1.Object) (bool, error) {
+	a := o.GetAnnotations()
+	s, ok := a[key]
+	if !ok {
+		return false, nil
+	}
+
+	r, v, err := decode(s)
+	if err != nil {
+		return false, err
+	}
+	if v == Version {
+		return false, nil
+	}
+
+	if err := SetMulticlusterControllerReference(o, r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// encodedReference is the annotation's payload since v1.
+// v1 and v2 only differ by ClusterUID, which is validated in decode.
+type encodedReference struct {
+	Version string `json:"version"`
+	MulticlusterOwnerReference
+}
+
+func decode(s string) (*MulticlusterOwnerReference, string, error) {
+	v := &struct {
+		Version string `json:"version"`
+	}{}
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		return nil, "", &invalidReferenceErr{s: fmt.Sprintf("malformed multicluster controller reference: %v", err)}
+	}
+
+	var r *MulticlusterOwnerReference
+	switch v.Version {
+	case "":
+		r = &MulticlusterOwnerReference{}
+		if err := decodeStrict(s, r); err != nil {
+			return nil, "", err
+		}
+	case versionV1, Version:
+		e := &encodedReference{}
+		if err := decodeStrict(s, e); err != nil {
+			return nil, "", err
+		}
+		r = &e.MulticlusterOwnerReference
+	default:
+		return nil, "", &invalidReferenceErr{s: fmt.Sprintf("unsupported multicluster controller reference version %q", v.Version)}
+	}
+
+	if v.Version != Version && r.ClusterUID != "" {
+		return nil, "", &invalidReferenceErr{s: fmt.Sprintf("invalid multicluster controller reference: clusterUID is not supported in version %q", v.Version)}
+	}
+
+	if err := validate(r); err != nil {
+		return nil, "", err
+	}
+	return r, v.Version, nil
+}
+
+func decodeStrict(s string, out interface{}) error {
+	d := json.NewDecoder(bytes.NewReader([]byte(s)))
+	d.DisallowUnknownFields()
+	if err := d.Decode(out); err != nil {
+		return &invalidReferenceErr{s: fmt.Sprintf("malformed multicluster controller reference: %v", err)}
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return &invalidReferenceErr{s: "malformed multicluster controller reference: unexpected data after JSON object"}
+	}
+	return nil
+}
+
+func validate(r *MulticlusterOwnerReference) error {
+	if _, err := schema.ParseGroupVersion(r.APIVersion); err != nil || r.APIVersion == "" {
+		return &invalidReferenceErr{s: fmt.Sprintf("invalid multicluster controller reference: invalid apiVersion %q", r.APIVersion)}
+	}
+	if r.Kind == "" {
+		return &invalidReferenceErr{s: "invalid multicluster controller reference: missing kind"}
+	}
+	if r.Name == "" {
+		return &invalidReferenceErr{s: "invalid multicluster controller reference: missing name"}
+	}
+	if r.ClusterName == "" {
+		return &invalidReferenceErr{s: "invalid multicluster controller reference: missing clusterName"}
+	}
+	return nil
+}
+
+type invalidReferenceErr struct {
+	s string
+}
+
+func (e *invalidReferenceErr) Error() string {
+	return e.s
+}
+
+// IsInvalidReferenceErr returns true if err was returned because a multicluster controller reference
+// annotation is malformed, invalid, or of an unsupported version.
+func IsInvalidReferenceErr(err error) bool {
+	_, ok := err.(*invalidReferenceErr)
+	return ok
+}